@@ -0,0 +1,205 @@
+package mrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/odysseythink/mrun/fleets"
+)
+
+// App is an independent application: it owns a module manager, the
+// namespace of the signals created by App.NewSignal and a goroutine pool.
+// The package level functions work on a default App. An App can be run again
+// once its previous run returned.
+type App struct {
+	name                string
+	mgr                 *ModuleMgr
+	pool                *fleets.Pool
+	poolSize            int
+	shutdownGracePeriod time.Duration
+	runMux              sync.Mutex
+	// guards subscriptions against the signals being created
+	busMux        sync.Mutex
+	subscriptions []*Subscription
+}
+
+type AppOption func(*App) error
+
+// NewAppPoolSizeOption sets the capacity of the App's goroutine pool.
+func NewAppPoolSizeOption(size int) func(*App) error {
+	return func(app *App) error {
+		if size <= 0 {
+			log.Printf("[E]invalid app pool size\n")
+			return errors.New("invalid app pool size")
+		}
+		app.poolSize = size
+		return nil
+	}
+}
+
+// NewAppShutdownGracePeriodOption bounds the time Run waits for the modules
+// to stop once the App is asked to exit, 0 waits forever.
+func NewAppShutdownGracePeriodOption(d time.Duration) func(*App) error {
+	return func(app *App) error {
+		if d < 0 {
+			log.Printf("[E]invalid app shutdown grace period\n")
+			return errors.New("invalid app shutdown grace period")
+		}
+		app.shutdownGracePeriod = d
+		return nil
+	}
+}
+
+func NewApp(name string, options ...AppOption) (*App, error) {
+	if name == "" {
+		log.Printf("[E]missing name\n")
+		return nil, errors.New("missing name")
+	}
+	app := &App{
+		name:                name,
+		mgr:                 NewModuleMgr(name),
+		poolSize:            fleets.DefaultAntsPoolSize,
+		shutdownGracePeriod: DEFAULT_SHUTDOWN_GRACE_PERIOD,
+	}
+	for _, option := range options {
+		err := option(app)
+		if err != nil {
+			log.Printf("[E]run app option func failed:%v\n", err)
+			return nil, err
+		}
+	}
+	pool, err := fleets.NewPool(app.poolSize)
+	if err != nil {
+		log.Printf("[E]new app(%s) pool failed:%v\n", name, err)
+		return nil, fmt.Errorf("new app(%s) pool failed:%v", name, err)
+	}
+	app.pool = pool
+	app.mgr.pool = pool
+	return app, nil
+}
+
+// ModuleMgr returns the module manager of the App.
+func (app *App) ModuleMgr() *ModuleMgr {
+	return app.mgr
+}
+
+func (app *App) Register(m IModule, options []ModuleMgrOption, args []any) error {
+	return app.mgr.Register(m, options, args...)
+}
+
+func (app *App) RegisterLibso(libname string, options []ModuleMgrOption, args []any) error {
+	return app.mgr.RegisterLibso(libname, options, args...)
+}
+
+func (app *App) RegisterLibsoWithModule(libname, modulename string, options []ModuleMgrOption, args []any) error {
+	return app.mgr.RegisterLibsoWithModule(libname, modulename, options, args...)
+}
+
+// Supervise sets the restart policy of the App's modules.
+func (app *App) Supervise(options ...SupervisorOption) {
+	app.mgr.Supervise(options...)
+}
+
+// NewSignal creates a signal in the App's namespace.
+func (app *App) NewSignal(name string, sigfunc any, options ...SignalOption) (*Signal, error) {
+	return newSignal(app, name, sigfunc, options...)
+}
+
+// Run registers m, runs the App until a signal of sig, SIGINT and SIGTERM by
+// default, is received, then shuts it down.
+func (app *App) Run(m IModule, sig ...os.Signal) error {
+	return app.RunWithArgs(m, nil, sig...)
+}
+
+// RunWithArgs is like Run, args are passed to the Init of m. If m implements
+// Context, the App also exits once its context is done.
+func (app *App) RunWithArgs(m IModule, args []any, sig ...os.Signal) error {
+	var ctx context.Context
+	if s, ok := m.(Context); ok && s != nil {
+		ctx = s.Context()
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return app.RunContext(ctx, m, args, sig...)
+}
+
+// RunContext is like RunWithArgs, the App exits once ctx is done. The
+// modules which don't stop within the shutdown grace period are reported by
+// a *ShutdownError and forgotten, they're destroyed in the background once
+// they stop, so the App can be run again.
+func (app *App) RunContext(ctx context.Context, m IModule, args []any, sig ...os.Signal) error {
+	app.runMux.Lock()
+	defer app.runMux.Unlock()
+	defer app.mgr.reset()
+
+	if app.pool != nil {
+		app.pool.Reboot()
+	} else {
+		fleets.Reboot()
+	}
+	if m != nil {
+		err := app.mgr.Register(m, nil, args...)
+		if err != nil {
+			log.Printf("[E]%s register module failed:%v\n", app.name, err)
+			return err
+		}
+	}
+	app.mgr.start(ctx)
+
+	err := app.mgr.Init()
+	if err != nil {
+		log.Printf("[E]%s init failed:%v\n", app.name, err)
+		app.shutdown()
+		return err
+	}
+	if len(sig) == 0 {
+		sig = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, sig...)
+	defer signal.Stop(signalChan)
+
+	select {
+	case sigQuit := <-signalChan:
+		log.Printf("[D]%s closing by signal %v\n", app.name, sigQuit)
+	case <-ctx.Done():
+		log.Printf("[D]%s closing by context done\n", app.name)
+	}
+
+	err = app.shutdown()
+	if err != nil {
+		log.Printf("[E]%s shutdown failed:%v\n", app.name, err)
+	}
+	log.Printf("[D]%s End!\n", app.name)
+	return err
+}
+
+func (app *App) shutdown() error {
+	ctx := context.Background()
+	if app.shutdownGracePeriod > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, app.shutdownGracePeriod)
+		defer cancel()
+	}
+	err := app.mgr.Shutdown(ctx)
+	var serr *ShutdownError
+	if errors.As(err, &serr) {
+		for _, v := range serr.Modules {
+			app.mgr.forget(v.Module)
+		}
+	}
+	if app.pool != nil {
+		app.pool.Release()
+	} else {
+		fleets.Release()
+	}
+	return err
+}
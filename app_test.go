@@ -0,0 +1,127 @@
+package mrun
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAppsAreIndependent(t *testing.T) {
+	a, err := NewApp("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewApp("b", NewAppPoolSizeOption(4), NewAppShutdownGracePeriodOption(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same signal name lives in each namespace
+	sa, err := a.NewSignal("changed", func(int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb, err := b.NewSignal("changed", func(int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.NewSignal("changed", func(int) {}); err == nil {
+		t.Fatal("duplicated signal in one app accepted")
+	}
+	var na, nb atomic.Int32
+	Connect(sa, func(int) { na.Add(1) })
+	Connect(sb, func(int) { nb.Add(1) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 2)
+	go func() { done <- a.RunContext(ctx, nil, nil) }()
+	go func() { done <- b.RunContext(ctx, nil, nil) }()
+
+	sa.Emit(1)
+	sa.Emit(2)
+	sb.Emit(3)
+	deadline := time.Now().Add(2 * time.Second)
+	for (na.Load() != 2 || nb.Load() != 1) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if na.Load() != 2 || nb.Load() != 1 {
+		t.Fatalf("got a=%d b=%d, want a=2 b=1", na.Load(), nb.Load())
+	}
+	cancel()
+	for range 2 {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAppRunAgain(t *testing.T) {
+	app, err := NewApp("again")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		rec := &recorder{}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- app.RunContext(ctx, &recordModule{name: "m", rec: rec}, nil) }()
+		deadline := time.Now().Add(2 * time.Second)
+		for len(app.ModuleMgr().Status()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("run %d:%v", i, err)
+		}
+		if got := rec.String(); got != "init:m,destroy:m" {
+			t.Fatalf("run %d got %q", i, got)
+		}
+		if n := len(app.ModuleMgr().Status()); n != 0 {
+			t.Fatalf("run %d left %d modules", i, n)
+		}
+	}
+}
+
+func TestAppRunAfterStopTimeout(t *testing.T) {
+	app, err := NewApp(t.Name(), NewAppShutdownGracePeriodOption(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{}
+	stuck := &drainModule{recordModule: recordModule{name: "stuck", rec: rec}, release: make(chan struct{})}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = app.RunContext(ctx, stuck, nil)
+	var serr *ShutdownError
+	if !errors.As(err, &serr) || len(serr.Modules) != 1 || serr.Modules[0].Module != stuck {
+		t.Fatalf("unexpected run error:%v", err)
+	}
+	if app.ModuleMgr().Contains(stuck) {
+		t.Fatal("module failing to stop still registered")
+	}
+	close(stuck.release)
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(rec.String(), "destroy:stuck") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := rec.String(); got != "init:stuck,drain:stuck,destroy:stuck" {
+		t.Fatalf("events=%s once stopped", got)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err = app.RunContext(ctx, stuck, nil); err != nil {
+		t.Fatalf("second run:%v", err)
+	}
+}
+
+func TestNewAppInvalidOption(t *testing.T) {
+	if _, err := NewApp("bad", NewAppPoolSizeOption(0)); err == nil {
+		t.Fatal("invalid pool size accepted")
+	}
+	if _, err := NewApp(""); err == nil {
+		t.Fatal("empty name accepted")
+	}
+}
@@ -7,18 +7,26 @@ import (
 	"log"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/odysseythink/mrun/fleets"
+	"github.com/odysseythink/mrun/metrics"
 )
 
 type BaseDataFlow struct {
 	processorsMux sync.RWMutex
 	processors    *list.List
+	// copy of processors the calls run on
+	chain         atomic.Pointer[processorChain]
 	ctx           context.Context
 	wg            sync.WaitGroup
 	ctxCancelFunc context.CancelFunc
 	initOnce      sync.Once
+	traceMux      sync.RWMutex
+	exporter      SpanExporter
+	registry      metrics.Registry
+	metricsPrefix string
 }
 
 func (df *BaseDataFlow) Contains(p IDataProcessor) bool {
@@ -80,7 +88,9 @@ func (df *BaseDataFlow) DeleteDataProcessorInfo(p IDataProcessor) {
 	for e != nil {
 		if e.Value.(*dataProcessorInfo).p == p {
 			df.processors.Remove(e)
-			df.processorsMux.RUnlock()
+			old := df.publish()
+			df.processorsMux.Unlock()
+			df.retire(old)
 			return
 		}
 		e = e.Next()
@@ -94,17 +104,23 @@ func (df *BaseDataFlow) addDataProcessor(info *dataProcessorInfo) {
 		return
 	}
 	df.processorsMux.Lock()
+	if df.processors == nil {
+		df.processors = list.New()
+	}
 	e := df.processors.Front()
 	for e != nil {
 		if e.Value.(*dataProcessorInfo).order >= info.order {
 			df.processors.InsertAfter(info, e)
-			df.processorsMux.Unlock()
-			return
+			break
 		}
 		e = e.Next()
 	}
-	df.processors.PushBack(info)
+	if e == nil {
+		df.processors.PushBack(info)
+	}
+	old := df.publish()
 	df.processorsMux.Unlock()
+	df.retire(old)
 }
 
 func (df *BaseDataFlow) UnRegister(p IDataProcessor) error {
@@ -117,22 +133,11 @@ func (df *BaseDataFlow) UnRegister(p IDataProcessor) error {
 		log.Printf("[E]data processor not register")
 		return fmt.Errorf("data processor not register")
 	}
-	// df.wg.Add(1)
-	// go func() {
-
-	if df.ctx != nil {
-		select {
-		case info.exitCh <- struct{}{}:
-			break
-		default:
-			// log.Println("notice exitCh failed")
-			break
-		}
-	}
-	info.p.Destroy()
+	// in-flight calls finish with the processor before it's destroyed
 	df.DeleteDataProcessorInfo(p)
-	// 	df.wg.Done()
-	// }()
+	info.stop()
+	info.p.Destroy()
+	df.releaseTimer(info)
 	return nil
 }
 
@@ -182,15 +187,19 @@ func (df *BaseDataFlow) Destroy() {
 		if df.processors == nil {
 			df.processors = list.New()
 		}
-		e := df.processors.Front()
-		for e != nil {
-			df.destroy(e.Value.(*dataProcessorInfo))
-			tmp := e.Next()
-			df.processors.Remove(e)
-			e = tmp
-
+		var infos []*dataProcessorInfo
+		for e := df.processors.Front(); e != nil; e = e.Next() {
+			infos = append(infos, e.Value.(*dataProcessorInfo))
 		}
+		df.processors.Init()
+		old := df.publish()
 		df.processorsMux.Unlock()
+
+		df.retire(old)
+		for _, info := range infos {
+			df.destroy(info)
+			df.releaseTimer(info)
+		}
 	}
 	df.wg.Wait()
 }
@@ -211,6 +220,28 @@ func (df *BaseDataFlow) Range(cb func(m IDataProcessor) bool) {
 	df.processorsMux.RUnlock()
 }
 
+// snapshot returns the registered processors in order, the flows call them
+// without holding processorsMux.
+func (df *BaseDataFlow) snapshot() []*dataProcessorInfo {
+	var infos []*dataProcessorInfo
+	df.processorsMux.RLock()
+	if df.processors != nil {
+		for e := df.processors.Front(); e != nil; e = e.Next() {
+			infos = append(infos, e.Value.(*dataProcessorInfo))
+		}
+	}
+	df.processorsMux.RUnlock()
+	return infos
+}
+
+// context returns the context of the flow, Background before Init.
+func (df *BaseDataFlow) context() context.Context {
+	if df.ctx != nil {
+		return df.ctx
+	}
+	return context.Background()
+}
+
 func (df *BaseDataFlow) destroy(info *dataProcessorInfo) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -230,7 +261,9 @@ func (df *BaseDataFlow) runDataProcessor(info *dataProcessorInfo) {
 	}
 
 	df.wg.Add(1)
+	info.loopDone = make(chan struct{})
 	go func() {
+		defer close(info.loopDone)
 		var err error
 		timer := time.NewTimer(1 * time.Millisecond)
 	LOOP:
@@ -0,0 +1,256 @@
+// Package config builds module managers from declarative YAML or JSON
+// documents, so that a deployment can be recomposed without recompiling:
+//
+//	name: ingest
+//	modules:
+//	  - factory: db
+//	    alias: db
+//	    priority: 1
+//	    args: [{dsn: "postgres://localhost/ingest"}]
+//	  - libso: ./plugins/cache.so
+//	    alias: cache
+//	    period: 100
+//	    depends_on: [db]
+//
+// Go modules are created by the factory registered under the given name by
+// RegisterFactory, plugin modules are loaded from the given .so path.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sync"
+
+	"github.com/odysseythink/mrun"
+	"github.com/odysseythink/mrun/jsonschema"
+	"gopkg.in/yaml.v3"
+)
+
+// Factory creates a new instance of a module.
+type Factory func() mrun.IModule
+
+type factoryInfo struct {
+	newModule Factory
+	argTypes  []reflect.Type
+	schemas   []*jsonschema.Schema
+}
+
+var (
+	factoriesMux sync.RWMutex
+	factories    = make(map[string]*factoryInfo)
+)
+
+// RegisterFactory registers a module factory under name. argSamples declare
+// the positional Init args of the module, one zero value per arg, e.g.
+// DBConfig{} or "". When given, the args of the configuration are checked
+// against the JSON schema reflected from each sample and decoded to its type
+// before being passed to Init, otherwise they are passed as decoded.
+func RegisterFactory(name string, factory Factory, argSamples ...any) error {
+	if name == "" || factory == nil {
+		log.Printf("[E]invalid arg\n")
+		return errors.New("invalid arg")
+	}
+	info := &factoryInfo{newModule: factory}
+	for idx, v := range argSamples {
+		if v == nil {
+			log.Printf("[E]factory(%s) arg sample %d is nil\n", name, idx)
+			return fmt.Errorf("factory(%s) arg sample %d is nil", name, idx)
+		}
+		t := reflect.TypeOf(v)
+		r := &jsonschema.Reflector{
+			Anonymous:      true,
+			ExpandedStruct: t.Kind() == reflect.Struct || (t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct),
+		}
+		info.argTypes = append(info.argTypes, t)
+		info.schemas = append(info.schemas, r.Reflect(v))
+	}
+
+	factoriesMux.Lock()
+	defer factoriesMux.Unlock()
+	if _, ok := factories[name]; ok {
+		log.Printf("[E]factory(%s) already register\n", name)
+		return fmt.Errorf("factory(%s) already register", name)
+	}
+	factories[name] = info
+	return nil
+}
+
+// Schemas returns the JSON schemas of the Init args of the factory
+// registered under name, nil if it doesn't declare them.
+func Schemas(name string) []*jsonschema.Schema {
+	factoriesMux.RLock()
+	defer factoriesMux.RUnlock()
+	if info, ok := factories[name]; ok {
+		return info.schemas
+	}
+	return nil
+}
+
+// ModuleConfig declares one module. Exactly one of Factory and Libso must be
+// set.
+type ModuleConfig struct {
+	Factory   string   `json:"factory,omitempty" yaml:"factory,omitempty"`
+	Libso     string   `json:"libso,omitempty" yaml:"libso,omitempty"`
+	Alias     string   `json:"alias,omitempty" yaml:"alias,omitempty"`
+	Priority  *int     `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Period    uint     `json:"period,omitempty" yaml:"period,omitempty"`
+	DependsOn []string `json:"depends_on,omitempty" yaml:"depends_on,omitempty"`
+	Args      []any    `json:"args,omitempty" yaml:"args,omitempty"`
+}
+
+// Config declares a module manager and its modules.
+type Config struct {
+	Name    string         `json:"name" yaml:"name"`
+	Modules []ModuleConfig `json:"modules" yaml:"modules"`
+}
+
+// Parse parses a YAML or JSON document.
+func Parse(data []byte) (*Config, error) {
+	c := &Config{}
+	// JSON is a subset of YAML
+	err := yaml.Unmarshal(data, c)
+	if err != nil {
+		log.Printf("[E]parse config failed:%v\n", err)
+		return nil, fmt.Errorf("parse config failed:%v", err)
+	}
+	return c, nil
+}
+
+// LoadFile parses the YAML or JSON document in path.
+func LoadFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("[E]read config(%s) failed:%v\n", path, err)
+		return nil, fmt.Errorf("read config(%s) failed:%v", path, err)
+	}
+	return Parse(data)
+}
+
+// Build creates a module manager named after the config and registers the
+// modules into it.
+func (c *Config) Build(options ...mrun.SupervisorOption) (*mrun.ModuleMgr, error) {
+	if c.Name == "" {
+		log.Printf("[E]config missing name\n")
+		return nil, errors.New("config missing name")
+	}
+	mgr := mrun.NewModuleMgr(c.Name, options...)
+	err := c.Apply(mgr)
+	if err != nil {
+		return nil, err
+	}
+	return mgr, nil
+}
+
+// Apply registers the modules into mgr. It stops at the first module failing
+// to register.
+func (c *Config) Apply(mgr *mrun.ModuleMgr) error {
+	if mgr == nil {
+		log.Printf("[E]invalid arg\n")
+		return errors.New("invalid arg")
+	}
+	for idx := range c.Modules {
+		err := c.Modules[idx].register(mgr)
+		if err != nil {
+			log.Printf("[E]register module %d failed:%v\n", idx, err)
+			return fmt.Errorf("register module %d failed:%v", idx, err)
+		}
+	}
+	return nil
+}
+
+func (mc *ModuleConfig) options() []mrun.ModuleMgrOption {
+	options := make([]mrun.ModuleMgrOption, 0)
+	if mc.Alias != "" {
+		options = append(options, mrun.NewModuleAliasOption(mc.Alias))
+	}
+	if mc.Priority != nil {
+		options = append(options, mrun.NewPriorityModuleMgrOption(*mc.Priority))
+	}
+	if mc.Period > 0 {
+		options = append(options, mrun.NewModuleRunPeriodOption(mc.Period))
+	}
+	if len(mc.DependsOn) > 0 {
+		options = append(options, mrun.NewModuleDependsOnOption(mc.DependsOn...))
+	}
+	return options
+}
+
+func (mc *ModuleConfig) register(mgr *mrun.ModuleMgr) error {
+	if (mc.Factory == "") == (mc.Libso == "") {
+		return errors.New("exactly one of factory and libso must be set")
+	}
+	if mc.Libso != "" {
+		args, err := normalize(mc.Args)
+		if err != nil {
+			return err
+		}
+		return mgr.RegisterLibso(mc.Libso, mc.options(), args...)
+	}
+
+	factoriesMux.RLock()
+	info, ok := factories[mc.Factory]
+	factoriesMux.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown factory(%s)", mc.Factory)
+	}
+	args, err := info.decodeArgs(mc.Args)
+	if err != nil {
+		return fmt.Errorf("factory(%s) %v", mc.Factory, err)
+	}
+	m := info.newModule()
+	if m == nil {
+		return fmt.Errorf("factory(%s) returned nil module", mc.Factory)
+	}
+	return mgr.Register(m, mc.options(), args...)
+}
+
+// normalize turns decoded YAML values into their JSON equivalent, i.e.
+// map[string]any, []any, float64, string, bool and nil.
+func normalize(args []any) ([]any, error) {
+	ret := make([]any, 0, len(args))
+	for idx, v := range args {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("arg %d:%v", idx, err)
+		}
+		var nv any
+		err = json.Unmarshal(data, &nv)
+		if err != nil {
+			return nil, fmt.Errorf("arg %d:%v", idx, err)
+		}
+		ret = append(ret, nv)
+	}
+	return ret, nil
+}
+
+func (info *factoryInfo) decodeArgs(args []any) ([]any, error) {
+	args, err := normalize(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.argTypes) == 0 {
+		return args, nil
+	}
+	if len(args) != len(info.argTypes) {
+		return nil, fmt.Errorf("expects %d args, got %d", len(info.argTypes), len(args))
+	}
+	ret := make([]any, 0, len(args))
+	for idx, v := range args {
+		err = validate(info.schemas[idx], info.schemas[idx], v, fmt.Sprintf("args[%d]", idx))
+		if err != nil {
+			return nil, err
+		}
+		data, _ := json.Marshal(v)
+		typed := reflect.New(info.argTypes[idx])
+		err = json.Unmarshal(data, typed.Interface())
+		if err != nil {
+			return nil, fmt.Errorf("decode args[%d] to %s failed:%v", idx, info.argTypes[idx], err)
+		}
+		ret = append(ret, typed.Elem().Interface())
+	}
+	return ret, nil
+}
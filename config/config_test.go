@@ -0,0 +1,141 @@
+package config
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/odysseythink/mrun"
+)
+
+type dbConfig struct {
+	DSN      string `json:"dsn" jsonschema:"minLength=1"`
+	PoolSize int    `json:"pool_size,omitempty" jsonschema:"minimum=1"`
+}
+
+type dbModule struct {
+	cfg  dbConfig
+	name string
+}
+
+func (m *dbModule) Init(args ...any) error {
+	m.cfg = args[0].(dbConfig)
+	m.name = args[1].(string)
+	return nil
+}
+func (m *dbModule) Destroy() {
+}
+func (m *dbModule) RunOnce(ctx context.Context) error {
+	return nil
+}
+func (m *dbModule) UserData() any {
+	return m
+}
+
+type plainModule struct {
+	args []any
+}
+
+func (m *plainModule) Init(args ...any) error {
+	m.args = args
+	return nil
+}
+func (m *plainModule) Destroy() {
+}
+func (m *plainModule) RunOnce(ctx context.Context) error {
+	return nil
+}
+func (m *plainModule) UserData() any {
+	return m
+}
+
+func init() {
+	RegisterFactory("test_db", func() mrun.IModule { return &dbModule{} }, dbConfig{}, "")
+	RegisterFactory("test_plain", func() mrun.IModule { return &plainModule{} })
+}
+
+func TestConfigBuild(t *testing.T) {
+	for _, doc := range []string{`
+name: test
+modules:
+  - factory: test_plain
+    alias: consumer
+    depends_on: [db]
+    args: [1, "x"]
+  - factory: test_db
+    alias: db
+    priority: 1
+    period: 50
+    args: [{dsn: "postgres://localhost", pool_size: 4}, "main"]
+`, `{
+  "name": "test",
+  "modules": [
+    {"factory": "test_plain", "alias": "consumer", "depends_on": ["db"], "args": [1, "x"]},
+    {"factory": "test_db", "alias": "db", "priority": 1, "period": 50,
+     "args": [{"dsn": "postgres://localhost", "pool_size": 4}, "main"]}
+  ]
+}`} {
+		c, err := Parse([]byte(doc))
+		if err != nil {
+			t.Fatalf("parse failed:%v", err)
+		}
+		mgr, err := c.Build()
+		if err != nil {
+			t.Fatalf("build failed:%v", err)
+		}
+		if err = mgr.Init(); err != nil {
+			t.Fatalf("init failed:%v", err)
+		}
+		db := mgr.GetModulesByAlias("db")[0].(*dbModule)
+		if db.cfg.DSN != "postgres://localhost" || db.cfg.PoolSize != 4 || db.name != "main" {
+			t.Fatalf("unexpected db args:%+v %s", db.cfg, db.name)
+		}
+		plain := mgr.GetModulesByAlias("consumer")[0].(*plainModule)
+		if len(plain.args) != 2 || plain.args[0] != float64(1) || plain.args[1] != "x" {
+			t.Fatalf("unexpected plain args:%#v", plain.args)
+		}
+		mgr.Destroy()
+	}
+}
+
+func TestConfigInvalidArgs(t *testing.T) {
+	tests := []struct {
+		args string
+		err  string
+	}{
+		{`[{pool_size: 4}, "main"]`, "args[0].dsn is required"},
+		{`[{dsn: ""}, "main"]`, "at least 1 characters"},
+		{`[{dsn: "x", pool_size: 0}, "main"]`, "args[0].pool_size must be >= 1"},
+		{`[{dsn: "x", pool_size: 1.5}, "main"]`, "must be integer"},
+		{`[{dsn: "x", other: 1}, "main"]`, "args[0].other is not allowed"},
+		{`[{dsn: "x"}, 2]`, "args[1] must be string"},
+		{`[{dsn: "x"}]`, "expects 2 args"},
+	}
+	for _, tt := range tests {
+		c, err := Parse([]byte("name: test\nmodules:\n  - factory: test_db\n    args: " + tt.args))
+		if err != nil {
+			t.Fatalf("parse failed:%v", err)
+		}
+		_, err = c.Build()
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("args %s: err=%v, want %s", tt.args, err, tt.err)
+		}
+	}
+}
+
+func TestConfigInvalidModule(t *testing.T) {
+	for _, doc := range []string{
+		"name: test\nmodules:\n  - alias: x",
+		"name: test\nmodules:\n  - factory: test_plain\n    libso: x.so",
+		"name: test\nmodules:\n  - factory: unknown",
+		"modules:\n  - factory: test_plain",
+	} {
+		c, err := Parse([]byte(doc))
+		if err != nil {
+			t.Fatalf("parse failed:%v", err)
+		}
+		if _, err = c.Build(); err == nil {
+			t.Errorf("build(%q) should fail", doc)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/odysseythink/mrun/jsonschema"
+)
+
+// validate checks v, a value decoded from JSON, against the subset of JSON
+// schema generated by jsonschema.Reflector. root holds the definitions refs
+// point to.
+func validate(root, s *jsonschema.Schema, v any, path string) error {
+	if s == nil || s == jsonschema.TrueSchema {
+		return nil
+	}
+	if s == jsonschema.FalseSchema {
+		return fmt.Errorf("%s is not allowed", path)
+	}
+	if s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, "#/$defs/")
+		if !ok || root.Definitions[name] == nil {
+			return fmt.Errorf("%s unsupported schema ref(%s)", path, s.Ref)
+		}
+		return validate(root, root.Definitions[name], v, path)
+	}
+	for _, sub := range s.AllOf {
+		if err := validate(root, sub, v, path); err != nil {
+			return err
+		}
+	}
+	if len(s.AnyOf) > 0 && countValid(root, s.AnyOf, v, path) == 0 {
+		return fmt.Errorf("%s matches none of anyOf", path)
+	}
+	if len(s.OneOf) > 0 && countValid(root, s.OneOf, v, path) != 1 {
+		return fmt.Errorf("%s doesn't match exactly one of oneOf", path)
+	}
+	if s.Type != "" && !matchType(s.Type, v) {
+		return fmt.Errorf("%s must be %s, got %s", path, s.Type, typeName(v))
+	}
+	if len(s.Enum) > 0 && !contains(s.Enum, v) {
+		return fmt.Errorf("%s must be one of %v", path, s.Enum)
+	}
+	if s.Const != nil && !equal(s.Const, v) {
+		return fmt.Errorf("%s must be %v", path, s.Const)
+	}
+
+	switch val := v.(type) {
+	case string:
+		l := uint64(len([]rune(val)))
+		if s.MinLength != nil && l < *s.MinLength {
+			return fmt.Errorf("%s must be at least %d characters", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && l > *s.MaxLength {
+			return fmt.Errorf("%s must be at most %d characters", path, *s.MaxLength)
+		}
+		if s.Pattern != "" {
+			re, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				return fmt.Errorf("%s invalid schema pattern(%s):%v", path, s.Pattern, err)
+			}
+			if !re.MatchString(val) {
+				return fmt.Errorf("%s must match %s", path, s.Pattern)
+			}
+		}
+	case float64:
+		if err := checkNumber(s.Minimum, path, func(n float64) bool { return val >= n }, "be >="); err != nil {
+			return err
+		}
+		if err := checkNumber(s.Maximum, path, func(n float64) bool { return val <= n }, "be <="); err != nil {
+			return err
+		}
+		if err := checkNumber(s.ExclusiveMinimum, path, func(n float64) bool { return val > n }, "be >"); err != nil {
+			return err
+		}
+		if err := checkNumber(s.ExclusiveMaximum, path, func(n float64) bool { return val < n }, "be <"); err != nil {
+			return err
+		}
+		if err := checkNumber(s.MultipleOf, path, func(n float64) bool { return n == 0 || math.Mod(val, n) == 0 }, "be a multiple of"); err != nil {
+			return err
+		}
+	case []any:
+		if s.MinItems != nil && uint64(len(val)) < *s.MinItems {
+			return fmt.Errorf("%s must have at least %d items", path, *s.MinItems)
+		}
+		if s.MaxItems != nil && uint64(len(val)) > *s.MaxItems {
+			return fmt.Errorf("%s must have at most %d items", path, *s.MaxItems)
+		}
+		for idx, item := range val {
+			if err := validate(root, s.Items, item, fmt.Sprintf("%s[%d]", path, idx)); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+		for name, item := range val {
+			sub, ok := s.Properties[name]
+			if !ok {
+				sub = s.AdditionalProperties
+				for pattern, ps := range s.PatternProperties {
+					if re, err := regexp.Compile(pattern); err == nil && re.MatchString(name) {
+						sub = ps
+						break
+					}
+				}
+			}
+			if err := validate(root, sub, item, path+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func countValid(root *jsonschema.Schema, schemas []*jsonschema.Schema, v any, path string) int {
+	n := 0
+	for _, sub := range schemas {
+		if validate(root, sub, v, path) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func checkNumber(limit json.Number, path string, ok func(float64) bool, desc string) error {
+	if limit == "" {
+		return nil
+	}
+	n, err := limit.Float64()
+	if err != nil {
+		return fmt.Errorf("%s invalid schema number(%s):%v", path, limit, err)
+	}
+	if !ok(n) {
+		return fmt.Errorf("%s must %s %s", path, desc, limit)
+	}
+	return nil
+}
+
+func matchType(t string, v any) bool {
+	switch t {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		n, ok := v.(float64)
+		return ok && n == math.Trunc(n)
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	}
+	return true
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func contains(values []any, v any) bool {
+	for _, e := range values {
+		if equal(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// equal compares schema values, which may be Go typed, with JSON decoded ones.
+func equal(a, b any) bool {
+	na, err := normalize([]any{a})
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(na[0], b)
+}
@@ -0,0 +1,145 @@
+package mrun
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed standard 5 fields cron expression:
+// minute hour day-of-month month day-of-week.
+type cronSchedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// a restricted day-of-month or day-of-week matches if either matches
+	domStar bool
+	dowStar bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if v, ok := cronDescriptors[expr]; ok {
+		expr = v
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression(%s) must have 5 fields", expr)
+	}
+	c := &cronSchedule{
+		domStar: fields[2] == "*" || fields[2] == "?",
+		dowStar: fields[4] == "*" || fields[4] == "?",
+	}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("cron expression(%s) minute:%v", expr, err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("cron expression(%s) hour:%v", expr, err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("cron expression(%s) day of month:%v", expr, err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("cron expression(%s) month:%v", expr, err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("cron expression(%s) day of week:%v", expr, err)
+	}
+	// 7 is sunday too
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parseCronField parses a comma separated list of "*", "n", "n-m" items,
+// each optionally followed by "/step", into a bit set.
+func parseCronField(field string, lo, hi uint) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(item, "/")
+		step := uint64(1)
+		if hasStep {
+			v, err := strconv.ParseUint(stepStr, 10, 8)
+			if err != nil || v == 0 {
+				return 0, fmt.Errorf("invalid step(%s)", stepStr)
+			}
+			step = v
+		}
+		start, end := uint64(lo), uint64(hi)
+		if rng != "*" && rng != "?" {
+			first, last, isRange := strings.Cut(rng, "-")
+			v, err := strconv.ParseUint(first, 10, 8)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value(%s)", first)
+			}
+			start = v
+			if isRange {
+				v, err = strconv.ParseUint(last, 10, 8)
+				if err != nil {
+					return 0, fmt.Errorf("invalid value(%s)", last)
+				}
+				end = v
+			} else if !hasStep {
+				end = start
+			}
+		}
+		if start < uint64(lo) || end > uint64(hi) || start > end {
+			return 0, fmt.Errorf("value(%s) out of range [%d,%d]", item, lo, hi)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// next returns the first matching time after t, or the zero time if nothing
+// matches within the next five years.
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
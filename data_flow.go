@@ -3,6 +3,10 @@ package mrun
 import (
 	"context"
 	"log"
+	"sync"
+	"time"
+
+	"github.com/odysseythink/mrun/metrics"
 )
 
 type DataFlowOption func(*dataProcessorInfo)
@@ -42,13 +46,34 @@ type IDataProcessor interface {
 	Process(msg any) (any, error)
 }
 
+// ContextDataProcessor is an optional interface a data processor can
+// implement to be handed the context of the flow's ProcessContext, which
+// carries the span of the processor.
+type ContextDataProcessor interface {
+	ProcessContext(ctx context.Context, msg any) (any, error)
+}
+
 type dataProcessorInfo struct {
 	p                IDataProcessor
 	args             []any
 	exitCh           chan struct{}
+	stopOnce         sync.Once
+	loopDone         chan struct{}
 	onProcessorError func(IDataProcessor, error)
 	processFaildFunc func(msg any, err error) (any, error)
 	order            uint
+	name             string
+	queueSize        int
+	workers          int
+	retries          int
+	minBackoff       time.Duration
+	maxBackoff       time.Duration
+	errorPolicy      errorPolicy
+	deadLetter       DeadLetterSink
+	counters         processorCounters
+	timerOnce        sync.Once
+	timerName        string
+	timer            metrics.Timer
 }
 
 type IDataFlow interface {
@@ -69,6 +94,11 @@ func NewDataFlow(protocol string) IDataFlow {
 		return &SequenceDataFlow{}
 	} else if protocol == "parallel" {
 		return &ParallelDataFlow{}
+	} else if protocol == "graph" {
+		return &GraphDataFlow{}
+	} else if protocol == "pipeline" {
+		df, _ := NewPipelineDataFlow()
+		return df
 	} else {
 		log.Printf("[E]NewDataFlow failed:unsupported protocal(%s)\n", protocol)
 		return nil
@@ -0,0 +1,310 @@
+package mrun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDeadLettered wraps the error of a message a processor sent to its
+// dead-letter sink.
+var ErrDeadLettered = errors.New("message dead-lettered")
+
+type errorPolicy int
+
+const (
+	// the flow fails, or ProcessFaild decides
+	errorPolicyFail errorPolicy = iota
+	// the message goes on to the next processor unchanged
+	errorPolicySkip
+	// the message is sent to the dead-letter sink and dropped
+	errorPolicyDeadLetter
+)
+
+// NewDataFlowRetryOption retries a failed Process up to attempts times,
+// waiting minBackoff before the first retry and doubling the delay up to
+// maxBackoff. MsgCheck failures aren't retried.
+func NewDataFlowRetryOption(attempts int, minBackoff, maxBackoff time.Duration) func(info *dataProcessorInfo) {
+	return func(info *dataProcessorInfo) {
+		if attempts < 0 || minBackoff < 0 || maxBackoff < minBackoff {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		info.retries = attempts
+		info.minBackoff = minBackoff
+		info.maxBackoff = maxBackoff
+	}
+}
+
+// NewDataFlowSkipOption makes a message the processor failed on, once the
+// retries are exhausted, go on to the next processor unchanged.
+func NewDataFlowSkipOption() func(info *dataProcessorInfo) {
+	return func(info *dataProcessorInfo) {
+		info.errorPolicy = errorPolicySkip
+	}
+}
+
+// NewDataFlowDeadLetterOption sends a message the processor failed on, once
+// the retries are exhausted, to sink. The flow then fails the message with
+// an error wrapping ErrDeadLettered.
+func NewDataFlowDeadLetterOption(sink DeadLetterSink) func(info *dataProcessorInfo) {
+	return func(info *dataProcessorInfo) {
+		if sink == nil {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		info.errorPolicy = errorPolicyDeadLetter
+		info.deadLetter = sink
+	}
+}
+
+// ProcessorStats are the counters of a data processor.
+type ProcessorStats struct {
+	Processed    uint64
+	Failed       uint64
+	Retried      uint64
+	Skipped      uint64
+	DeadLettered uint64
+}
+
+type processorCounters struct {
+	processed    atomic.Uint64
+	failed       atomic.Uint64
+	retried      atomic.Uint64
+	skipped      atomic.Uint64
+	deadLettered atomic.Uint64
+}
+
+// Stats returns the counters of p, false if it isn't registered.
+func (df *BaseDataFlow) Stats(p IDataProcessor) (ProcessorStats, bool) {
+	info := df.GetDataProcessorInfo(p)
+	if info == nil {
+		return ProcessorStats{}, false
+	}
+	return ProcessorStats{
+		Processed:    info.counters.processed.Load(),
+		Failed:       info.counters.failed.Load(),
+		Retried:      info.counters.retried.Load(),
+		Skipped:      info.counters.skipped.Load(),
+		DeadLettered: info.counters.deadLettered.Load(),
+	}, true
+}
+
+func (info *dataProcessorInfo) processorName() string {
+	if info.name != "" {
+		return info.name
+	}
+	return fmt.Sprintf("%T", info.p)
+}
+
+// process calls Process once, panics are turned into errors.
+func (info *dataProcessorInfo) process(ctx context.Context, msg any) (out any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 4096)
+			l := runtime.Stack(buf, false)
+			log.Printf("[E]%v: %s\n", r, buf[:l])
+			out, err = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+	if p, ok := info.p.(ContextDataProcessor); ok {
+		return p.ProcessContext(ctx, msg)
+	}
+	return info.p.Process(msg)
+}
+
+func (info *dataProcessorInfo) backoff(n int) time.Duration {
+	delay := info.minBackoff
+	for range n {
+		if delay >= info.maxBackoff/2 {
+			return info.maxBackoff
+		}
+		delay *= 2
+	}
+	return min(delay, info.maxBackoff)
+}
+
+// run hands msg to the processor applying its retry and error policy. A
+// skipped message is returned unchanged with a nil error.
+func (info *dataProcessorInfo) run(ctx context.Context, msg any) (any, SpanStatus, error) {
+	attempts := 0
+	err := info.p.MsgCheck(msg)
+	if err != nil {
+		log.Printf("[E]MsgCheck failed:%v\n", err)
+	} else {
+		var out any
+		for {
+			attempts++
+			out, err = info.process(ctx, msg)
+			if err == nil {
+				info.counters.processed.Add(1)
+				return out, SpanOK, nil
+			}
+			log.Printf("[E]process failed:%v\n", err)
+			if attempts > info.retries {
+				break
+			}
+			info.counters.retried.Add(1)
+			timer := time.NewTimer(info.backoff(attempts - 1))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				info.counters.failed.Add(1)
+				return nil, SpanError, errors.Join(err, ctx.Err())
+			case <-timer.C:
+			}
+		}
+	}
+	info.counters.failed.Add(1)
+
+	switch info.errorPolicy {
+	case errorPolicySkip:
+		info.counters.skipped.Add(1)
+		return msg, SpanSkipped, nil
+	case errorPolicyDeadLetter:
+		info.counters.deadLettered.Add(1)
+		letter := DeadLetter{
+			Time:      time.Now(),
+			Processor: info.processorName(),
+			Msg:       msg,
+			Err:       err,
+			Attempts:  attempts,
+		}
+		perr := info.deadLetter.Put(letter)
+		if perr != nil {
+			log.Printf("[E]put dead letter failed:%v\n", perr)
+			return nil, SpanDeadLettered, fmt.Errorf("%w:%w, put dead letter failed:%v", ErrDeadLettered, err, perr)
+		}
+		return nil, SpanDeadLettered, fmt.Errorf("%w:%w", ErrDeadLettered, err)
+	}
+	return nil, SpanError, err
+}
+
+// failed hands the error of run to the ProcessFaild callback, if any, whose
+// result then replaces the processor's. Dead-lettered messages aren't.
+func (info *dataProcessorInfo) failed(msg any, err error) (any, error) {
+	if info.processFaildFunc != nil && !errors.Is(err, ErrDeadLettered) {
+		return info.processFaildFunc(msg, err)
+	}
+	return nil, err
+}
+
+// DeadLetter is a message a processor failed on.
+type DeadLetter struct {
+	Time      time.Time
+	Processor string
+	Msg       any
+	Err       error
+	// Process calls, 0 if MsgCheck rejected the message
+	Attempts int
+}
+
+// DeadLetterSink stores the messages processors gave up on.
+type DeadLetterSink interface {
+	Put(letter DeadLetter) error
+}
+
+// MemoryDeadLetterSink keeps the last dead letters in memory.
+type MemoryDeadLetterSink struct {
+	mux     sync.Mutex
+	cap     int
+	letters []DeadLetter
+}
+
+// NewMemoryDeadLetterSink returns a sink keeping the last cap letters, all
+// of them if cap is 0.
+func NewMemoryDeadLetterSink(cap int) *MemoryDeadLetterSink {
+	if cap < 0 {
+		log.Printf("[E]invalid arg\n")
+		return nil
+	}
+	return &MemoryDeadLetterSink{cap: cap}
+}
+
+func (s *MemoryDeadLetterSink) Put(letter DeadLetter) error {
+	s.mux.Lock()
+	s.letters = append(s.letters, letter)
+	if s.cap > 0 && len(s.letters) > s.cap {
+		s.letters = append(s.letters[:0], s.letters[len(s.letters)-s.cap:]...)
+	}
+	s.mux.Unlock()
+	return nil
+}
+
+// Letters returns the kept letters, oldest first.
+func (s *MemoryDeadLetterSink) Letters() []DeadLetter {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]DeadLetter(nil), s.letters...)
+}
+
+// Reset drops the kept letters.
+func (s *MemoryDeadLetterSink) Reset() {
+	s.mux.Lock()
+	s.letters = nil
+	s.mux.Unlock()
+}
+
+// FileDeadLetterSink appends dead letters to a file, one JSON object per
+// line. Messages which can't be marshaled are written formatted by %v.
+type FileDeadLetterSink struct {
+	mux  sync.Mutex
+	file *os.File
+}
+
+type fileDeadLetter struct {
+	Time      time.Time       `json:"time"`
+	Processor string          `json:"processor"`
+	Error     string          `json:"error"`
+	Attempts  int             `json:"attempts"`
+	Msg       json.RawMessage `json:"msg"`
+}
+
+// NewFileDeadLetterSink opens path for appending, creating it if needed.
+func NewFileDeadLetterSink(path string) (*FileDeadLetterSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("[E]open dead letter file(%s) failed:%v\n", path, err)
+		return nil, fmt.Errorf("open dead letter file(%s) failed:%v", path, err)
+	}
+	return &FileDeadLetterSink{file: f}, nil
+}
+
+func (s *FileDeadLetterSink) Put(letter DeadLetter) error {
+	msg, err := json.Marshal(letter.Msg)
+	if err != nil {
+		msg, _ = json.Marshal(fmt.Sprintf("%v", letter.Msg))
+	}
+	line := fileDeadLetter{
+		Time:      letter.Time,
+		Processor: letter.Processor,
+		Attempts:  letter.Attempts,
+		Msg:       msg,
+	}
+	if letter.Err != nil {
+		line.Error = letter.Err.Error()
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	_, err = s.file.Write(data)
+	return err
+}
+
+// Close closes the file.
+func (s *FileDeadLetterSink) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,161 @@
+package mrun
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDataFlowRetry(t *testing.T) {
+	calls := 0
+	p := &funcProcessor{fn: func(msg any) (any, error) {
+		calls++
+		if calls < 3 {
+			return nil, errors.New("transient")
+		}
+		return msg, nil
+	}}
+	df := NewDataFlow("sequence")
+	df.Register(p, []DataFlowOption{NewDataFlowRetryOption(2, time.Millisecond, 4*time.Millisecond)})
+	df.Init()
+	defer df.Destroy()
+
+	out, err := df.Process(1)
+	if err != nil || out != 1 {
+		t.Fatalf("got %v %v", out, err)
+	}
+	stats, _ := df.(*SequenceDataFlow).Stats(p)
+	if stats != (ProcessorStats{Processed: 1, Retried: 2}) {
+		t.Fatalf("got %+v", stats)
+	}
+}
+
+func TestDataFlowSkipAndDeadLetter(t *testing.T) {
+	fail := errors.New("bad message")
+	skipper := &funcProcessor{fn: func(msg any) (any, error) {
+		if msg.(int) < 0 {
+			return nil, fail
+		}
+		return msg.(int) * 2, nil
+	}}
+	sink := NewMemoryDeadLetterSink(1)
+	checker := &funcProcessor{fn: func(msg any) (any, error) {
+		if msg.(int) == 0 {
+			return nil, fail
+		}
+		return msg, nil
+	}}
+	df := &SequenceDataFlow{}
+	df.Register(skipper, []DataFlowOption{NewDataFlowOrderOption(0), NewDataFlowSkipOption()})
+	df.Register(checker, []DataFlowOption{NewDataFlowOrderOption(1), NewDataFlowNameOption("checker"), NewDataFlowDeadLetterOption(sink)})
+	df.Init()
+	defer df.Destroy()
+
+	if out, err := df.Process(-1); err != nil || out != -1 {
+		t.Fatalf("skipped message got %v %v", out, err)
+	}
+	for range 2 {
+		if _, err := df.Process(0); !errors.Is(err, ErrDeadLettered) || !errors.Is(err, fail) {
+			t.Fatalf("got %v", err)
+		}
+	}
+	letters := sink.Letters()
+	if len(letters) != 1 || letters[0].Processor != "checker" || letters[0].Msg != 0 || letters[0].Attempts != 1 {
+		t.Fatalf("got %+v", letters)
+	}
+	if stats, _ := df.Stats(skipper); stats.Skipped != 1 || stats.Failed != 1 || stats.Processed != 2 {
+		t.Fatalf("got %+v", stats)
+	}
+	if stats, _ := df.Stats(checker); stats.DeadLettered != 2 || stats.Processed != 1 {
+		t.Fatalf("got %+v", stats)
+	}
+}
+
+func TestDataFlowFailureReleasesLock(t *testing.T) {
+	df := &SequenceDataFlow{}
+	p := &funcProcessor{fn: func(msg any) (any, error) { return nil, errors.New("fail") }}
+	df.Register(p, nil)
+	df.Init()
+	if _, err := df.Process(1); err == nil {
+		t.Fatal("error not reported")
+	}
+	if _, err := df.Process("x"); err == nil {
+		t.Fatal("MsgCheck error not reported")
+	}
+	done := make(chan struct{})
+	go func() {
+		df.UnRegister(p)
+		df.Destroy()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("processorsMux leaked")
+	}
+}
+
+func TestPipelineDeadLetter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	sink, err := NewFileDeadLetterSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	df, _ := NewPipelineDataFlow()
+	df.Register(&funcProcessor{fn: func(msg any) (any, error) {
+		if msg.(int)%10 == 0 {
+			return nil, errors.New("bad")
+		}
+		return msg, nil
+	}}, []DataFlowOption{NewDataFlowNameOption("decode"), NewDataFlowDeadLetterOption(sink)})
+	df.Init()
+	go func() {
+		for i := range 100 {
+			df.Submit(i)
+		}
+		df.Drain(context.Background())
+	}()
+	ok, dead := 0, 0
+	for result := range df.Results() {
+		if errors.Is(result.Err, ErrDeadLettered) {
+			dead++
+		} else {
+			ok++
+		}
+	}
+	df.Destroy()
+	sink.Close()
+	if ok != 90 || dead != 10 {
+		t.Fatalf("got %d ok, %d dead", ok, dead)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var letter struct {
+			Processor string `json:"processor"`
+			Error     string `json:"error"`
+			Msg       int    `json:"msg"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			t.Fatal(err)
+		}
+		if letter.Processor != "decode" || letter.Error != "bad" || letter.Msg%10 != 0 {
+			t.Fatalf("got %+v", letter)
+		}
+		lines++
+	}
+	if lines != 10 {
+		t.Fatalf("got %d dead letters", lines)
+	}
+}
@@ -0,0 +1,359 @@
+package mrun
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// processorChain is an immutable copy of the processors of a flow. Every
+// change of the flow publishes a new chain, calls run on the chain they
+// acquired so they never see a half-changed flow, and a retired chain waits
+// for its calls before its removed processors are destroyed. Every change
+// retires the chain it replaced.
+type processorChain struct {
+	mux     sync.RWMutex
+	retired bool
+	// the chain this one replaced, until it's retired
+	prev  *processorChain
+	infos []*dataProcessorInfo
+}
+
+// publish makes the processors list the current chain and returns the
+// previous one, processorsMux must be held.
+func (df *BaseDataFlow) publish() *processorChain {
+	c := &processorChain{infos: make([]*dataProcessorInfo, 0, df.processors.Len())}
+	for e := df.processors.Front(); e != nil; e = e.Next() {
+		c.infos = append(c.infos, e.Value.(*dataProcessorInfo))
+	}
+	c.prev = df.chain.Load()
+	df.chain.Store(c)
+	return c.prev
+}
+
+// processors returns the processors of c in order, none if c is nil.
+func (c *processorChain) processors() []*dataProcessorInfo {
+	if c == nil {
+		return nil
+	}
+	return c.infos
+}
+
+// acquire returns the current chain, which isn't retired until release.
+func (df *BaseDataFlow) acquire() *processorChain {
+	for {
+		c := df.chain.Load()
+		if c == nil {
+			return nil
+		}
+		c.mux.RLock()
+		if !c.retired {
+			return c
+		}
+		// retired in between, the new chain is published already
+		c.mux.RUnlock()
+	}
+}
+
+func (df *BaseDataFlow) release(c *processorChain) {
+	if c != nil {
+		c.mux.RUnlock()
+	}
+}
+
+// retire waits for the calls running on c and on the chains before it, a
+// change retiring its chain ahead of the one before doesn't return early.
+// It must not be called by a processor of the flow while it handles a
+// message, so processors don't change their flow from Process.
+func (df *BaseDataFlow) retire(c *processorChain) {
+	if c == nil {
+		return
+	}
+	c.mux.RLock()
+	prev := c.prev
+	c.mux.RUnlock()
+	df.retire(prev)
+
+	c.mux.Lock()
+	c.retired = true
+	c.prev = nil
+	c.mux.Unlock()
+}
+
+// stop makes the RunOnce loop of info exit.
+func (info *dataProcessorInfo) stop() {
+	info.stopOnce.Do(func() {
+		if info.exitCh != nil {
+			close(info.exitCh)
+		}
+	})
+}
+
+// stopProcessor stops and destroys a processor removed from the flow once
+// the chains using it are retired.
+func (df *BaseDataFlow) stopProcessor(info *dataProcessorInfo) {
+	info.stop()
+	if info.loopDone != nil {
+		<-info.loopDone
+	}
+	df.destroy(info)
+	df.releaseTimer(info)
+}
+
+func newDataProcessorInfo(p IDataProcessor, options []DataFlowOption, args []any) *dataProcessorInfo {
+	info := &dataProcessorInfo{
+		p:      p,
+		exitCh: make(chan struct{}),
+		order:  999,
+	}
+	if args != nil {
+		info.args = make([]any, 0)
+		info.args = append(info.args, args...)
+	}
+	for _, v := range options {
+		v(info)
+	}
+	return info
+}
+
+// DataProcessorSpec describes a processor of the chain set by SwapChain.
+type DataProcessorSpec struct {
+	Processor IDataProcessor
+	Options   []DataFlowOption
+	Args      []any
+}
+
+// Replace replaces the processor at order by p in a single step: calls
+// running when it's called finish with the old processor, which is then
+// destroyed, later calls use p. If the flow is running p is initialized
+// first, the flow is left unchanged if that fails.
+func (df *SequenceDataFlow) Replace(order uint, p IDataProcessor, options []DataFlowOption, args ...any) error {
+	if p == nil {
+		log.Printf("[E]invalid arg\n")
+		return fmt.Errorf("invalid arg")
+	}
+	if df.Contains(p) {
+		log.Printf("[E]already register\n")
+		return fmt.Errorf("already register")
+	}
+	info := newDataProcessorInfo(p, options, args)
+	info.order = order
+	running := df.ctx != nil
+	if running {
+		err := p.Init(args...)
+		if err != nil {
+			log.Printf("[E]data processor init failed:%v\n", err)
+			return fmt.Errorf("data processor init failed:%v", err)
+		}
+	}
+
+	df.processorsMux.Lock()
+	var replaced *dataProcessorInfo
+	if df.processors != nil {
+		for e := df.processors.Front(); e != nil; e = e.Next() {
+			if e.Value.(*dataProcessorInfo).order == order {
+				replaced = e.Value.(*dataProcessorInfo)
+				e.Value = info
+				break
+			}
+		}
+	}
+	if replaced == nil {
+		df.processorsMux.Unlock()
+		if running {
+			p.Destroy()
+		}
+		log.Printf("[E]order(%d) not register\n", order)
+		return fmt.Errorf("order(%d) not register", order)
+	}
+	old := df.publish()
+	df.processorsMux.Unlock()
+
+	if running {
+		df.runDataProcessor(info)
+	}
+	df.retire(old)
+	df.stopProcessor(replaced)
+	return nil
+}
+
+// InsertAfter inserts p right after the processor at order, the processors
+// after it move one order up. It's a single step as well, calls see the
+// chain either with or without p.
+func (df *SequenceDataFlow) InsertAfter(order uint, p IDataProcessor, options []DataFlowOption, args ...any) error {
+	if p == nil {
+		log.Printf("[E]invalid arg\n")
+		return fmt.Errorf("invalid arg")
+	}
+	if df.Contains(p) {
+		log.Printf("[E]already register\n")
+		return fmt.Errorf("already register")
+	}
+	info := newDataProcessorInfo(p, options, args)
+	info.order = order + 1
+	running := df.ctx != nil
+	if running {
+		err := p.Init(args...)
+		if err != nil {
+			log.Printf("[E]data processor init failed:%v\n", err)
+			return fmt.Errorf("data processor init failed:%v", err)
+		}
+	}
+
+	df.processorsMux.Lock()
+	var at *list.Element
+	if df.processors != nil {
+		for e := df.processors.Front(); e != nil; e = e.Next() {
+			if e.Value.(*dataProcessorInfo).order == order {
+				at = e
+				break
+			}
+		}
+	}
+	if at == nil {
+		df.processorsMux.Unlock()
+		if running {
+			p.Destroy()
+		}
+		log.Printf("[E]order(%d) not register\n", order)
+		return fmt.Errorf("order(%d) not register", order)
+	}
+	for e := at.Next(); e != nil; e = e.Next() {
+		e.Value.(*dataProcessorInfo).order++
+	}
+	df.processors.InsertAfter(info, at)
+	old := df.publish()
+	df.processorsMux.Unlock()
+
+	if running {
+		df.runDataProcessor(info)
+	}
+	df.retire(old)
+	return nil
+}
+
+// SwapChain replaces the whole chain by specs, in a single step. The
+// processors take the order of their spec, an order option in the specs is
+// rejected. Processors of the current chain found in specs are kept as they
+// are, the others are destroyed once the calls using them finished. If the
+// flow is running the new processors are initialized first, outside of the
+// flow's lock so their Init may call the flow, and the flow is left
+// unchanged if any of them fails or if a kept processor was removed
+// meanwhile.
+func (df *SequenceDataFlow) SwapChain(specs []DataProcessorSpec) error {
+	current := make(map[IDataProcessor]*dataProcessorInfo)
+	for _, info := range df.snapshot() {
+		current[info.p] = info
+	}
+	seen := make(map[IDataProcessor]bool, len(specs))
+	infos := make([]*dataProcessorInfo, 0, len(specs))
+	var added []*dataProcessorInfo
+	for _, spec := range specs {
+		if spec.Processor == nil || seen[spec.Processor] || hasOrderOption(spec.Options) {
+			log.Printf("[E]invalid arg\n")
+			return fmt.Errorf("invalid arg")
+		}
+		seen[spec.Processor] = true
+		info, ok := current[spec.Processor]
+		if !ok {
+			info = newDataProcessorInfo(spec.Processor, spec.Options, spec.Args)
+			added = append(added, info)
+		}
+		infos = append(infos, info)
+	}
+
+	running := df.ctx != nil
+	destroyAdded := func(added []*dataProcessorInfo) {
+		if running {
+			for _, info := range added {
+				info.p.Destroy()
+			}
+		}
+	}
+	if running {
+		for i, info := range added {
+			err := info.p.Init(info.args...)
+			if err != nil {
+				destroyAdded(added[:i])
+				log.Printf("[E]data processor init failed:%v\n", err)
+				return fmt.Errorf("data processor init failed:%v", err)
+			}
+		}
+	}
+
+	df.processorsMux.Lock()
+	if df.processors == nil {
+		df.processors = list.New()
+	}
+	now := make(map[IDataProcessor]*dataProcessorInfo, df.processors.Len())
+	for e := df.processors.Front(); e != nil; e = e.Next() {
+		now[e.Value.(*dataProcessorInfo).p] = e.Value.(*dataProcessorInfo)
+	}
+	// the flow may have changed while the new processors were initialized
+	for _, info := range infos {
+		if now[info.p] != current[info.p] {
+			df.processorsMux.Unlock()
+			destroyAdded(added)
+			log.Printf("[E]data processors changed during swap\n")
+			return fmt.Errorf("data processors changed during swap")
+		}
+	}
+	var removed []*dataProcessorInfo
+	for e := df.processors.Front(); e != nil; e = e.Next() {
+		if !seen[e.Value.(*dataProcessorInfo).p] {
+			removed = append(removed, e.Value.(*dataProcessorInfo))
+		}
+	}
+	df.processors = list.New()
+	for i, info := range infos {
+		info.order = uint(i)
+		df.processors.PushBack(info)
+	}
+	old := df.publish()
+	df.processorsMux.Unlock()
+
+	if running {
+		for _, info := range added {
+			df.runDataProcessor(info)
+		}
+	}
+	df.retire(old)
+	for _, info := range removed {
+		df.stopProcessor(info)
+	}
+	return nil
+}
+
+// hasOrderOption reports whether options set the order of a processor.
+func hasOrderOption(options []DataFlowOption) bool {
+	info := &dataProcessorInfo{order: ^uint(0)}
+	for _, v := range options {
+		v(info)
+	}
+	return info.order != ^uint(0)
+}
+
+func (df *PipelineDataFlow) Replace(order uint, p IDataProcessor, options []DataFlowOption, args ...any) error {
+	if df.ctx != nil {
+		log.Printf("[E]pipeline already started\n")
+		return fmt.Errorf("pipeline already started")
+	}
+	return df.SequenceDataFlow.Replace(order, p, options, args...)
+}
+
+func (df *PipelineDataFlow) InsertAfter(order uint, p IDataProcessor, options []DataFlowOption, args ...any) error {
+	if df.ctx != nil {
+		log.Printf("[E]pipeline already started\n")
+		return fmt.Errorf("pipeline already started")
+	}
+	return df.SequenceDataFlow.InsertAfter(order, p, options, args...)
+}
+
+func (df *PipelineDataFlow) SwapChain(specs []DataProcessorSpec) error {
+	if df.ctx != nil {
+		log.Printf("[E]pipeline already started\n")
+		return fmt.Errorf("pipeline already started")
+	}
+	return df.SequenceDataFlow.SwapChain(specs)
+}
@@ -0,0 +1,295 @@
+package mrun
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type destroyRecorder struct {
+	funcProcessor
+	destroyed atomic.Bool
+}
+
+func (p *destroyRecorder) Destroy() {
+	p.destroyed.Store(true)
+}
+
+func newTestSequence(t *testing.T, ps ...IDataProcessor) *SequenceDataFlow {
+	t.Helper()
+	df := &SequenceDataFlow{}
+	for i, p := range ps {
+		err := df.Register(p, []DataFlowOption{NewDataFlowOrderOption(uint(i))})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := df.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return df
+}
+
+func TestSequenceDataFlowSwapChain(t *testing.T) {
+	add := func(n int) *funcProcessor {
+		return &funcProcessor{fn: func(msg any) (any, error) { return msg.(int) + n, nil }}
+	}
+	mul := func(n int) *funcProcessor {
+		return &funcProcessor{fn: func(msg any) (any, error) { return msg.(int) * n, nil }}
+	}
+	df := newTestSequence(t, add(1), mul(10))
+	defer df.Destroy()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				out, err := df.Process(i)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				// either the whole old chain or the whole new one
+				if got := out.(int); got != (i+1)*10 && got != i*100+3 {
+					t.Errorf("Process(%d) = %d, a mix of chains", i, got)
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	err := df.SwapChain([]DataProcessorSpec{{Processor: mul(100)}, {Processor: add(3)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	if out, _ := df.Process(1); out != 103 {
+		t.Fatalf("Process(1) = %v after swap, want 103", out)
+	}
+	if df.ProcessorNum() != 2 {
+		t.Fatalf("got %d processors, want 2", df.ProcessorNum())
+	}
+}
+
+// reentrantInit calls its flow from Init
+type reentrantInit struct {
+	funcProcessor
+	df   *SequenceDataFlow
+	num  int
+	hook func()
+}
+
+func (p *reentrantInit) Init(args ...any) error {
+	p.num = p.df.ProcessorNum()
+	if p.hook != nil {
+		p.hook()
+	}
+	return nil
+}
+
+func TestSequenceDataFlowSwapChainInit(t *testing.T) {
+	id := func(msg any) (any, error) { return msg, nil }
+	first := &funcProcessor{fn: id}
+	df := newTestSequence(t, first)
+	defer df.Destroy()
+
+	p := &reentrantInit{funcProcessor: funcProcessor{fn: id}, df: df}
+	done := make(chan error, 1)
+	go func() {
+		done <- df.SwapChain([]DataProcessorSpec{{Processor: first}, {Processor: p}})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SwapChain deadlocked on a processor calling its flow from Init")
+	}
+	if p.num != 1 || df.ProcessorNum() != 2 {
+		t.Fatalf("Init saw %d processors, flow has %d", p.num, df.ProcessorNum())
+	}
+
+	err := df.SwapChain([]DataProcessorSpec{{Processor: &funcProcessor{fn: id}, Options: []DataFlowOption{NewDataFlowOrderOption(5)}}})
+	if err == nil {
+		t.Fatal("order option accepted")
+	}
+	if df.ProcessorNum() != 2 {
+		t.Fatal("rejected swap changed the flow")
+	}
+
+	// a kept processor removed while the new ones are initialized
+	q := &reentrantInit{funcProcessor: funcProcessor{fn: id}, df: df, hook: func() { df.UnRegister(first) }}
+	if err = df.SwapChain([]DataProcessorSpec{{Processor: first}, {Processor: q}}); err == nil {
+		t.Fatal("swap kept an unregistered processor")
+	}
+	if df.Contains(q) || df.ProcessorNum() != 1 {
+		t.Fatal("failed swap changed the flow")
+	}
+}
+
+func TestSequenceDataFlowReplaceWaitsInFlight(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	old := &destroyRecorder{funcProcessor: funcProcessor{fn: func(msg any) (any, error) {
+		close(entered)
+		<-release
+		return msg.(int) + 1, nil
+	}}}
+	df := newTestSequence(t, old)
+	defer df.Destroy()
+
+	result := make(chan any, 1)
+	go func() {
+		out, _ := df.Process(1)
+		result <- out
+	}()
+	<-entered
+
+	replaced := make(chan error, 1)
+	go func() {
+		replaced <- df.Replace(0, &funcProcessor{fn: func(msg any) (any, error) { return msg.(int) + 2, nil }}, nil)
+	}()
+	// the new processor takes the later calls right away
+	deadline := time.Now().Add(time.Second)
+	for df.Contains(old) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if out, _ := df.Process(1); out != 3 {
+		t.Fatalf("Process(1) = %v during replace, want 3", out)
+	}
+	select {
+	case <-replaced:
+		t.Fatal("Replace returned before the in-flight call finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if old.destroyed.Load() {
+		t.Fatal("old processor destroyed while in use")
+	}
+
+	close(release)
+	if out := <-result; out != 2 {
+		t.Fatalf("in-flight Process(1) = %v, want 2 from the old processor", out)
+	}
+	if err := <-replaced; err != nil {
+		t.Fatal(err)
+	}
+	if !old.destroyed.Load() {
+		t.Fatal("old processor not destroyed")
+	}
+}
+
+func TestSequenceDataFlowReplaceWaitsOlderChains(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var early atomic.Bool
+	var old *destroyRecorder
+	old = &destroyRecorder{funcProcessor: funcProcessor{fn: func(msg any) (any, error) {
+		close(entered)
+		<-release
+		early.Store(old.destroyed.Load())
+		return msg.(int) + 1, nil
+	}}}
+	df := newTestSequence(t, old)
+	defer df.Destroy()
+
+	result := make(chan any, 1)
+	go func() {
+		out, _ := df.Process(1)
+		result <- out
+	}()
+	<-entered
+
+	// the insert publishes a chain over the in-flight one, the replace
+	// after it must still wait for the call on the first chain
+	inserted := make(chan error, 1)
+	x := &funcProcessor{fn: func(msg any) (any, error) { return msg, nil }}
+	go func() {
+		inserted <- df.InsertAfter(0, x, nil)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for !df.Contains(x) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	replaced := make(chan error, 1)
+	go func() {
+		replaced <- df.Replace(0, &funcProcessor{fn: func(msg any) (any, error) { return msg.(int) + 2, nil }}, nil)
+	}()
+	for df.Contains(old) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if old.destroyed.Load() {
+		t.Fatal("old processor destroyed while in use")
+	}
+
+	close(release)
+	if out := <-result; out != 2 {
+		t.Fatalf("in-flight Process(1) = %v, want 2 from the old processor", out)
+	}
+	if err := <-inserted; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-replaced; err != nil {
+		t.Fatal(err)
+	}
+	if early.Load() {
+		t.Fatal("old processor destroyed before its call returned")
+	}
+	if !old.destroyed.Load() {
+		t.Fatal("old processor not destroyed")
+	}
+}
+
+func TestSequenceDataFlowInsertAfter(t *testing.T) {
+	var trace []string
+	step := func(name string) *funcProcessor {
+		return &funcProcessor{fn: func(msg any) (any, error) {
+			trace = append(trace, name)
+			return msg, nil
+		}}
+	}
+	df := newTestSequence(t, step("a"), step("c"))
+	defer df.Destroy()
+
+	b := step("b")
+	if err := df.InsertAfter(0, b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := df.InsertAfter(7, step("x"), nil); err == nil {
+		t.Fatal("InsertAfter an unknown order succeeded")
+	}
+	if _, err := df.Process(0); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(trace); got != 3 || trace[0] != "a" || trace[1] != "b" || trace[2] != "c" {
+		t.Fatalf("processors ran as %v, want [a b c]", trace)
+	}
+	if df.getDataProcessorByOrder(1) != b || df.getDataProcessorByOrder(2) == nil {
+		t.Fatal("orders not shifted after the insert")
+	}
+}
+
+func TestPipelineDataFlowRejectsSwapOnceStarted(t *testing.T) {
+	df := newTestPipeline(t, func(msg any) (any, error) { return msg, nil })
+	defer df.Destroy()
+
+	if err := df.Replace(0, &funcProcessor{}, nil); err == nil {
+		t.Fatal("Replace on a started pipeline succeeded")
+	}
+	if err := df.SwapChain(nil); err == nil {
+		t.Fatal("SwapChain on a started pipeline succeeded")
+	}
+}
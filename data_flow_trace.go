@@ -0,0 +1,253 @@
+package mrun
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/odysseythink/mrun/metrics"
+)
+
+// SpanContext identifies a span of a trace, it's carried by the context
+// handed to ProcessContext and to the processors implementing
+// ContextDataProcessor.
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying sc, the spans of the data
+// flows called with it become its children.
+func ContextWithSpan(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanFromContext returns the span carried by ctx.
+func SpanFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok
+}
+
+type SpanStatus string
+
+const (
+	SpanOK           SpanStatus = "ok"
+	SpanError        SpanStatus = "error"
+	SpanSkipped      SpanStatus = "skipped"
+	SpanDeadLettered SpanStatus = "dead-lettered"
+)
+
+// Span is a finished unit of work: the whole call of a data flow, or the
+// handling of a message by one of its processors.
+type Span struct {
+	TraceID  string        `json:"trace_id"`
+	SpanID   string        `json:"span_id"`
+	ParentID string        `json:"parent_id,omitempty"`
+	Name     string        `json:"name"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Status   SpanStatus    `json:"status"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// SpanExporter receives the finished spans of a data flow. ExportSpan is
+// called on the goroutine which processed the message, it must be safe for
+// concurrent use and should not block.
+type SpanExporter interface {
+	ExportSpan(span Span)
+}
+
+// JSONSpanExporter writes every span as a JSON object on its own line.
+type JSONSpanExporter struct {
+	mux sync.Mutex
+	enc *json.Encoder
+}
+
+func NewJSONSpanExporter(w io.Writer) *JSONSpanExporter {
+	if w == nil {
+		log.Printf("[E]invalid arg\n")
+		return nil
+	}
+	return &JSONSpanExporter{enc: json.NewEncoder(w)}
+}
+
+// NewStdoutSpanExporter returns a JSONSpanExporter writing to stdout.
+func NewStdoutSpanExporter() *JSONSpanExporter {
+	return NewJSONSpanExporter(os.Stdout)
+}
+
+func (e *JSONSpanExporter) ExportSpan(span Span) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	err := e.enc.Encode(span)
+	if err != nil {
+		log.Printf("[E]export span failed:%v\n", err)
+	}
+}
+
+func newSpanID(n int) string {
+	b := make([]byte, n)
+	// crypto/rand doesn't fail on supported platforms
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// span is a running span, nil when the flow has no exporter.
+type span struct {
+	Span
+	exporter SpanExporter
+}
+
+// startSpan starts a child of the span carried by ctx, or the root of a new
+// trace, and returns the context carrying it.
+func (df *BaseDataFlow) startSpan(ctx context.Context, name string) (context.Context, *span) {
+	df.traceMux.RLock()
+	exporter := df.exporter
+	df.traceMux.RUnlock()
+	if exporter == nil {
+		return ctx, nil
+	}
+	s := &span{exporter: exporter}
+	s.Name = name
+	s.Start = time.Now()
+	s.SpanID = newSpanID(8)
+	if parent, ok := SpanFromContext(ctx); ok {
+		s.TraceID = parent.TraceID
+		s.ParentID = parent.SpanID
+	} else {
+		s.TraceID = newSpanID(16)
+	}
+	return ContextWithSpan(ctx, SpanContext{TraceID: s.TraceID, SpanID: s.SpanID}), s
+}
+
+func (s *span) end(status SpanStatus, err error) {
+	if s == nil {
+		return
+	}
+	s.Duration = time.Since(s.Start)
+	s.Status = status
+	if err != nil {
+		s.Error = err.Error()
+	}
+	s.exporter.ExportSpan(s.Span)
+}
+
+// endWith ends the root span of a call according to its error.
+func (s *span) endWith(err error) {
+	switch {
+	case err == nil:
+		s.end(SpanOK, nil)
+	case errors.Is(err, ErrDeadLettered):
+		s.end(SpanDeadLettered, err)
+	default:
+		s.end(SpanError, err)
+	}
+}
+
+// SetSpanExporter makes the flow export a span per call and one per
+// processor handling a message, nil stops the export.
+func (df *BaseDataFlow) SetSpanExporter(exporter SpanExporter) {
+	df.traceMux.Lock()
+	df.exporter = exporter
+	df.traceMux.Unlock()
+}
+
+// SetMetricsRegistry sets the registry the latency timers of the processors
+// are registered into, named prefix followed by the processor name. Every
+// processor has its own timer, those sharing a name get #2, #3 and so on
+// appended in the order they first record. It must
+// be called before the first message is processed, by default every flow
+// has its own registry.
+func (df *BaseDataFlow) SetMetricsRegistry(r metrics.Registry, prefix string) {
+	if r == nil {
+		log.Printf("[E]invalid arg\n")
+		return
+	}
+	df.traceMux.Lock()
+	df.registry = r
+	df.metricsPrefix = prefix
+	df.traceMux.Unlock()
+}
+
+// Metrics returns the registry holding the latency timers of the processors.
+func (df *BaseDataFlow) Metrics() metrics.Registry {
+	df.traceMux.Lock()
+	defer df.traceMux.Unlock()
+	if df.registry == nil {
+		df.registry = metrics.NewRegistry()
+	}
+	return df.registry
+}
+
+// Timer returns the latency timer of p, nil if it isn't registered.
+func (df *BaseDataFlow) Timer(p IDataProcessor) metrics.Timer {
+	info := df.GetDataProcessorInfo(p)
+	if info == nil {
+		return nil
+	}
+	return df.timer(info)
+}
+
+func (df *BaseDataFlow) timer(info *dataProcessorInfo) metrics.Timer {
+	info.timerOnce.Do(func() {
+		registry := df.Metrics()
+		df.traceMux.RLock()
+		name := df.metricsPrefix + info.processorName()
+		df.traceMux.RUnlock()
+		timer := metrics.NewTimer()
+		info.timerName = name
+		for i := 2; ; i++ {
+			err := registry.Register(info.timerName, timer)
+			if err == nil {
+				break
+			}
+			var dup metrics.DuplicateMetric
+			if !errors.As(err, &dup) {
+				log.Printf("[E]register timer(%s) failed:%v\n", info.timerName, err)
+				info.timerName = ""
+				break
+			}
+			info.timerName = name + "#" + strconv.Itoa(i)
+		}
+		info.timer = timer
+	})
+	return info.timer
+}
+
+// releaseTimer unregisters the timer of info, which stops it. Processors
+// still running afterwards record into a stopped or nil timer.
+func (df *BaseDataFlow) releaseTimer(info *dataProcessorInfo) {
+	unused := false
+	info.timerOnce.Do(func() {
+		info.timer = metrics.NilTimer{}
+		unused = true
+	})
+	if unused || info.timerName == "" {
+		return
+	}
+	// only the timer info registered, never one of another processor
+	registry := df.Metrics()
+	if registry.Get(info.timerName) == info.timer {
+		registry.Unregister(info.timerName)
+	}
+}
+
+// runProcessor runs info on msg within a span, recording its latency.
+func (df *BaseDataFlow) runProcessor(ctx context.Context, info *dataProcessorInfo, msg any) (any, error) {
+	ctx, s := df.startSpan(ctx, info.processorName())
+	start := time.Now()
+	out, status, err := info.run(ctx, msg)
+	df.timer(info).UpdateSince(start)
+	s.end(status, err)
+	return out, err
+}
@@ -0,0 +1,150 @@
+package mrun
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/odysseythink/mrun/metrics"
+)
+
+type memorySpanExporter struct {
+	sync.Mutex
+	spans []Span
+}
+
+func (e *memorySpanExporter) ExportSpan(span Span) {
+	e.Lock()
+	e.spans = append(e.spans, span)
+	e.Unlock()
+}
+
+type spanProcessor struct {
+	funcProcessor
+	seen SpanContext
+}
+
+func (p *spanProcessor) ProcessContext(ctx context.Context, msg any) (any, error) {
+	p.seen, _ = SpanFromContext(ctx)
+	return p.Process(msg)
+}
+
+func TestSequenceDataFlowTracing(t *testing.T) {
+	decode := &spanProcessor{funcProcessor: funcProcessor{fn: func(msg any) (any, error) {
+		time.Sleep(5 * time.Millisecond)
+		return msg, nil
+	}}}
+	fail := errors.New("dropped")
+	persist := &funcProcessor{fn: func(msg any) (any, error) {
+		if msg.(int) < 0 {
+			return nil, fail
+		}
+		return msg, nil
+	}}
+	df := &SequenceDataFlow{}
+	df.Register(decode, []DataFlowOption{NewDataFlowOrderOption(0), NewDataFlowNameOption("decode")})
+	df.Register(persist, []DataFlowOption{NewDataFlowOrderOption(1), NewDataFlowNameOption("persist")})
+	registry := metrics.NewRegistry()
+	df.SetMetricsRegistry(registry, "ingest.")
+	exporter := &memorySpanExporter{}
+	df.SetSpanExporter(exporter)
+	df.Init()
+	defer df.Destroy()
+
+	parent := SpanContext{TraceID: "trace", SpanID: "caller"}
+	if _, err := df.ProcessContext(ContextWithSpan(context.Background(), parent), 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := df.Process(-1); !errors.Is(err, fail) {
+		t.Fatalf("got %v", err)
+	}
+
+	timer, ok := registry.Get("ingest.decode").(metrics.Timer)
+	if !ok || timer.Count() != 2 || time.Duration(timer.Min()) < 5*time.Millisecond {
+		t.Fatalf("decode timer not recorded:%v", registry.Get("ingest.decode"))
+	}
+	if df.Timer(persist).Count() != 2 {
+		t.Fatalf("got %d persist latencies", df.Timer(persist).Count())
+	}
+
+	spans := exporter.spans
+	if len(spans) != 6 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	// spans are exported as they end: decode, persist, then the call
+	root := spans[2]
+	if root.Name != "sequence" || root.TraceID != "trace" || root.ParentID != "caller" || root.Status != SpanOK {
+		t.Fatalf("got root %+v", root)
+	}
+	if spans[0].Name != "decode" || spans[0].ParentID != root.SpanID || spans[0].Duration < 5*time.Millisecond {
+		t.Fatalf("got decode span %+v", spans[0])
+	}
+	// the processor saw its own span of the last call
+	if decode.seen.SpanID != spans[3].SpanID || decode.seen.TraceID != spans[3].TraceID {
+		t.Fatalf("processor got span %+v, want %+v", decode.seen, spans[3])
+	}
+	// the second call is a new trace, dropped by persist
+	if spans[4].Name != "persist" || spans[4].Status != SpanError || spans[4].Error != "dropped" {
+		t.Fatalf("got persist span %+v", spans[4])
+	}
+	if spans[5].TraceID == "trace" || spans[5].Status != SpanError {
+		t.Fatalf("got root %+v", spans[5])
+	}
+}
+
+func TestJSONSpanExporter(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewJSONSpanExporter(&buf)
+	e.ExportSpan(Span{TraceID: "t", SpanID: "s", Name: "decode", Duration: time.Millisecond, Status: SpanSkipped})
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["name"] != "decode" || got["status"] != "skipped" || got["duration"] != float64(time.Millisecond) {
+		t.Fatalf("got %v", got)
+	}
+	if _, ok := got["parent_id"]; ok {
+		t.Fatal("empty parent exported")
+	}
+}
+
+func TestDataFlowTimerPerProcessor(t *testing.T) {
+	slow := &funcProcessor{fn: func(msg any) (any, error) {
+		time.Sleep(5 * time.Millisecond)
+		return msg, nil
+	}}
+	fast := &funcProcessor{fn: func(msg any) (any, error) { return msg, nil }}
+	df := &SequenceDataFlow{}
+	df.Register(slow, []DataFlowOption{NewDataFlowOrderOption(0)})
+	df.Register(fast, []DataFlowOption{NewDataFlowOrderOption(1)})
+	registry := metrics.NewRegistry()
+	df.SetMetricsRegistry(registry, "")
+	df.Init()
+	defer df.Destroy()
+
+	if _, err := df.Process(1); err != nil {
+		t.Fatal(err)
+	}
+	slowTimer, fastTimer := df.Timer(slow), df.Timer(fast)
+	if slowTimer == fastTimer || slowTimer.Count() != 1 || fastTimer.Count() != 1 {
+		t.Fatal("processors of the same type share a timer")
+	}
+	if time.Duration(fastTimer.Max()) >= 5*time.Millisecond {
+		t.Fatal("fast processor recorded the slow latency")
+	}
+	if registry.Get("*mrun.funcProcessor") != slowTimer || registry.Get("*mrun.funcProcessor#2") != fastTimer {
+		t.Fatal("timers not registered under distinct names")
+	}
+
+	// removing one processor leaves the timer of the other registered
+	if err := df.UnRegister(slow); err != nil {
+		t.Fatal(err)
+	}
+	if registry.Get("*mrun.funcProcessor") != nil || registry.Get("*mrun.funcProcessor#2") != fastTimer {
+		t.Fatal("unregister released the wrong timer")
+	}
+}
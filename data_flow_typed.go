@@ -0,0 +1,205 @@
+package mrun
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// Stage is a data processor with typed input and output. Stages are
+// composed by Chain, which only compiles if the output of a stage is the
+// input of the next one, and plugged into a data flow by AsDataProcessor or
+// NewTypedDataFlow.
+type Stage[In, Out any] interface {
+	Init(args ...any) error
+	RunOnce(ctx context.Context) error
+	Destroy()
+	UserData() any
+	Process(msg In) (Out, error)
+}
+
+type funcStage[In, Out any] struct {
+	fn func(In) (Out, error)
+}
+
+// StageFunc returns a stateless Stage calling fn.
+func StageFunc[In, Out any](fn func(In) (Out, error)) Stage[In, Out] {
+	return &funcStage[In, Out]{fn: fn}
+}
+
+func (s *funcStage[In, Out]) Init(args ...any) error {
+	return nil
+}
+
+func (s *funcStage[In, Out]) RunOnce(ctx context.Context) error {
+	return nil
+}
+
+func (s *funcStage[In, Out]) Destroy() {
+}
+
+func (s *funcStage[In, Out]) UserData() any {
+	return nil
+}
+
+func (s *funcStage[In, Out]) Process(msg In) (Out, error) {
+	return s.fn(msg)
+}
+
+type chainStage[A, B, C any] struct {
+	first  Stage[A, B]
+	second Stage[B, C]
+}
+
+// Chain returns the stage feeding the output of first to second. Longer
+// chains are built by nesting, e.g. Chain(Chain(decode, enrich), persist).
+// Both stages get the args of Init, they're destroyed in reverse order and
+// the UserData of the chain is the one of second.
+func Chain[A, B, C any](first Stage[A, B], second Stage[B, C]) Stage[A, C] {
+	return &chainStage[A, B, C]{first: first, second: second}
+}
+
+func (s *chainStage[A, B, C]) Init(args ...any) error {
+	err := s.first.Init(args...)
+	if err != nil {
+		return err
+	}
+	err = s.second.Init(args...)
+	if err != nil {
+		s.first.Destroy()
+		return err
+	}
+	return nil
+}
+
+func (s *chainStage[A, B, C]) RunOnce(ctx context.Context) error {
+	err := s.first.RunOnce(ctx)
+	if err != nil {
+		return err
+	}
+	return s.second.RunOnce(ctx)
+}
+
+func (s *chainStage[A, B, C]) Destroy() {
+	s.second.Destroy()
+	s.first.Destroy()
+}
+
+func (s *chainStage[A, B, C]) UserData() any {
+	return s.second.UserData()
+}
+
+func (s *chainStage[A, B, C]) Process(msg A) (C, error) {
+	out, err := s.first.Process(msg)
+	if err != nil {
+		var zero C
+		return zero, err
+	}
+	return s.second.Process(out)
+}
+
+// StageAdapter adapts a Stage to IDataProcessor, MsgCheck rejects messages
+// which aren't of type In.
+type StageAdapter[In, Out any] struct {
+	s Stage[In, Out]
+}
+
+// AsDataProcessor adapts s to IDataProcessor so it can be registered into
+// any data flow.
+func AsDataProcessor[In, Out any](s Stage[In, Out]) *StageAdapter[In, Out] {
+	return &StageAdapter[In, Out]{s: s}
+}
+
+// Stage returns the adapted stage.
+func (a *StageAdapter[In, Out]) Stage() Stage[In, Out] {
+	return a.s
+}
+
+func (a *StageAdapter[In, Out]) Init(args ...any) error {
+	return a.s.Init(args...)
+}
+
+func (a *StageAdapter[In, Out]) RunOnce(ctx context.Context) error {
+	return a.s.RunOnce(ctx)
+}
+
+func (a *StageAdapter[In, Out]) Destroy() {
+	a.s.Destroy()
+}
+
+func (a *StageAdapter[In, Out]) UserData() any {
+	return a.s.UserData()
+}
+
+func (a *StageAdapter[In, Out]) MsgCheck(msg any) error {
+	if _, ok := msg.(In); !ok {
+		var want In
+		return fmt.Errorf("stage(%T) expects msg of type %T, got %T", a.s, want, msg)
+	}
+	return nil
+}
+
+func (a *StageAdapter[In, Out]) Process(msg any) (any, error) {
+	in, ok := msg.(In)
+	if !ok {
+		return nil, a.MsgCheck(msg)
+	}
+	return a.s.Process(in)
+}
+
+// TypedDataFlow runs a single, usually chained, stage within the lifecycle
+// of a sequence data flow: Init, RunOnce and Destroy are driven by the flow
+// while Process is typed.
+type TypedDataFlow[In, Out any] struct {
+	df    SequenceDataFlow
+	stage Stage[In, Out]
+}
+
+// NewTypedDataFlow returns a flow running s, args are passed to its Init.
+func NewTypedDataFlow[In, Out any](s Stage[In, Out], options []DataFlowOption, args ...any) (*TypedDataFlow[In, Out], error) {
+	if s == nil {
+		log.Printf("[E]invalid arg\n")
+		return nil, fmt.Errorf("invalid arg")
+	}
+	df := &TypedDataFlow[In, Out]{stage: s}
+	err := df.df.Register(AsDataProcessor(s), options, args...)
+	if err != nil {
+		return nil, err
+	}
+	return df, nil
+}
+
+func (df *TypedDataFlow[In, Out]) Init() error {
+	return df.df.Init()
+}
+
+func (df *TypedDataFlow[In, Out]) Destroy() {
+	df.df.Destroy()
+}
+
+// Process runs msg through the flow, so the options given to
+// NewTypedDataFlow apply to the stage. It fails with ErrDataFlowClosed
+// before Init and once the flow is destroyed. A message skipped by
+// NewDataFlowSkipOption comes back unchanged, it's an error unless In is
+// Out.
+func (df *TypedDataFlow[In, Out]) Process(msg In) (Out, error) {
+	var zero Out
+	if df.df.ctx == nil || df.df.ctx.Err() != nil {
+		return zero, ErrDataFlowClosed
+	}
+	out, err := df.df.Process(msg)
+	if err != nil || out == nil {
+		return zero, err
+	}
+	ret, ok := out.(Out)
+	if !ok {
+		log.Printf("[E]stage(%T) returned msg of type %T, want %T\n", df.stage, out, zero)
+		return zero, fmt.Errorf("stage(%T) returned msg of type %T, want %T", df.stage, out, zero)
+	}
+	return ret, nil
+}
+
+// DataFlow returns the untyped flow running the stage.
+func (df *TypedDataFlow[In, Out]) DataFlow() IDataFlow {
+	return &df.df
+}
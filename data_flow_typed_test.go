@@ -0,0 +1,122 @@
+package mrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type lifecycleStage struct {
+	name string
+	rec  *recorder
+}
+
+func (s *lifecycleStage) Init(args ...any) error {
+	s.rec.add("init:" + s.name)
+	return nil
+}
+func (s *lifecycleStage) RunOnce(ctx context.Context) error {
+	return nil
+}
+func (s *lifecycleStage) Destroy() {
+	s.rec.add("destroy:" + s.name)
+}
+func (s *lifecycleStage) UserData() any {
+	return s.name
+}
+func (s *lifecycleStage) Process(msg string) (int, error) {
+	return strconv.Atoi(msg)
+}
+
+func TestTypedDataFlowOptions(t *testing.T) {
+	calls := 0
+	flaky := StageFunc(func(v int) (int, error) {
+		calls++
+		if calls < 3 {
+			return 0, errors.New("flaky")
+		}
+		return v + 1, nil
+	})
+	df, err := NewTypedDataFlow(flaky, []DataFlowOption{NewDataFlowRetryOption(3, time.Millisecond, time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = df.Process(1); !errors.Is(err, ErrDataFlowClosed) {
+		t.Fatalf("Process before Init got %v, want ErrDataFlowClosed", err)
+	}
+	if err = df.Init(); err != nil {
+		t.Fatal(err)
+	}
+	out, err := df.Process(1)
+	if err != nil || out != 2 || calls != 3 {
+		t.Fatalf("got %d %v after %d calls, want 2 after 3 retried calls", out, err, calls)
+	}
+	df.Destroy()
+	if _, err = df.Process(1); !errors.Is(err, ErrDataFlowClosed) {
+		t.Fatalf("Process after Destroy got %v, want ErrDataFlowClosed", err)
+	}
+}
+
+func TestTypedDataFlow(t *testing.T) {
+	rec := &recorder{}
+	decode := &lifecycleStage{name: "decode", rec: rec}
+	double := StageFunc(func(v int) (int, error) { return v * 2, nil })
+	format := StageFunc(func(v int) (string, error) { return fmt.Sprintf("<%d>", v), nil })
+
+	df, err := NewTypedDataFlow(Chain(Chain(decode, double), format), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = df.Init(); err != nil {
+		t.Fatal(err)
+	}
+	out, err := df.Process("21")
+	if err != nil || out != "<42>" {
+		t.Fatalf("got %q %v", out, err)
+	}
+	if _, err = df.Process("x"); err == nil {
+		t.Fatal("decode error not reported")
+	}
+	df.Destroy()
+	if got := rec.String(); got != "init:decode,destroy:decode" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestStageAdapter(t *testing.T) {
+	df := NewDataFlow("sequence")
+	fail := errors.New("negative")
+	df.Register(AsDataProcessor(StageFunc(func(v int) (int, error) {
+		if v < 0 {
+			return 0, fail
+		}
+		return v + 1, nil
+	})), nil)
+	if err := df.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer df.Destroy()
+	out, err := df.Process(1)
+	if err != nil || out != 2 {
+		t.Fatalf("got %v %v", out, err)
+	}
+	a := AsDataProcessor(StageFunc(func(v int) (int, error) { return v, nil }))
+	if err = a.MsgCheck("1"); err == nil {
+		t.Fatal("wrong msg type accepted")
+	}
+}
+
+func ExampleChain() {
+	parse := StageFunc(strconv.Atoi)
+	square := StageFunc(func(v int) (int, error) { return v * v, nil })
+	// Chain(square, parse) doesn't compile: parse takes a string
+	df, _ := NewTypedDataFlow(Chain(parse, square), nil)
+	df.Init()
+	defer df.Destroy()
+	out, _ := df.Process("12")
+	fmt.Println(out)
+	// Output: 144
+}
@@ -0,0 +1,584 @@
+package mrun
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+type WindowKind int
+
+const (
+	// TumblingWindow splits the messages of a key into back-to-back windows
+	// which don't overlap.
+	TumblingWindow WindowKind = iota
+	// SlidingWindow emits, every slide, the last size of the messages of a
+	// key, consecutive windows overlap.
+	SlidingWindow
+	// SessionWindow groups the messages of a key until no message arrived
+	// for a gap.
+	SessionWindow
+)
+
+func (k WindowKind) String() string {
+	switch k {
+	case TumblingWindow:
+		return "tumbling"
+	case SlidingWindow:
+		return "sliding"
+	case SessionWindow:
+		return "session"
+	}
+	return fmt.Sprintf("WindowKind(%d)", int(k))
+}
+
+// Reducer aggregates the values of a window, a new one is made per window.
+type Reducer interface {
+	Add(v float64)
+	Result() any
+}
+
+type countReducer struct {
+	n int
+}
+
+// NewCountReducer counts the values, its result is an int.
+func NewCountReducer() Reducer {
+	return &countReducer{}
+}
+
+func (r *countReducer) Add(v float64) {
+	r.n++
+}
+
+func (r *countReducer) Result() any {
+	return r.n
+}
+
+type sumReducer struct {
+	sum float64
+}
+
+// NewSumReducer sums the values, its result is a float64.
+func NewSumReducer() Reducer {
+	return &sumReducer{}
+}
+
+func (r *sumReducer) Add(v float64) {
+	r.sum += v
+}
+
+func (r *sumReducer) Result() any {
+	return r.sum
+}
+
+type extremumReducer struct {
+	max bool
+	set bool
+	v   float64
+}
+
+// NewMinReducer keeps the smallest value, its result is a float64.
+func NewMinReducer() Reducer {
+	return &extremumReducer{}
+}
+
+// NewMaxReducer keeps the largest value, its result is a float64.
+func NewMaxReducer() Reducer {
+	return &extremumReducer{max: true}
+}
+
+func (r *extremumReducer) Add(v float64) {
+	if !r.set || (r.max && v > r.v) || (!r.max && v < r.v) {
+		r.v = v
+		r.set = true
+	}
+}
+
+func (r *extremumReducer) Result() any {
+	return r.v
+}
+
+type percentilesReducer struct {
+	// sorted values of the window
+	values []float64
+	ps     []float64
+}
+
+// PercentilesReducer returns a reducer factory computing the percentiles ps,
+// e.g. 0.5 and 0.99, of the values, its result is a []float64 in the order
+// of ps. All the values of a window are kept, sorted, until it closes.
+func PercentilesReducer(ps ...float64) func() Reducer {
+	return func() Reducer {
+		return &percentilesReducer{ps: ps}
+	}
+}
+
+func (r *percentilesReducer) Add(v float64) {
+	i := sort.SearchFloat64s(r.values, v)
+	r.values = append(r.values, 0)
+	copy(r.values[i+1:], r.values[i:])
+	r.values[i] = v
+}
+
+// Result interpolates the percentiles like metrics.SamplePercentiles.
+func (r *percentilesReducer) Result() any {
+	scores := make([]float64, len(r.ps))
+	size := len(r.values)
+	if size == 0 {
+		return scores
+	}
+	for i, p := range r.ps {
+		pos := p * float64(size+1)
+		switch {
+		case pos < 1:
+			scores[i] = r.values[0]
+		case pos >= float64(size):
+			scores[i] = r.values[size-1]
+		default:
+			lower, upper := r.values[int(pos)-1], r.values[int(pos)]
+			scores[i] = lower + (pos-math.Floor(pos))*(upper-lower)
+		}
+	}
+	return scores
+}
+
+// WindowResult is a closed window.
+type WindowResult struct {
+	Key   any
+	Start time.Time
+	End   time.Time
+	// messages in the window
+	Count int
+	// result of the reducer
+	Value any
+}
+
+type windowConfig struct {
+	size   int
+	slide  int
+	length time.Duration
+	every  time.Duration
+	gap    time.Duration
+	value  func(msg any) (float64, error)
+	emit   func(WindowResult)
+}
+
+type WindowOption func(*windowConfig)
+
+// NewWindowCountOption triggers the window by count: a tumbling window
+// closes after size messages, a sliding window emits the last size messages
+// every slide messages. slide is ignored by tumbling windows.
+func NewWindowCountOption(size, slide int) WindowOption {
+	return func(cfg *windowConfig) {
+		if size <= 0 || slide < 0 {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		cfg.size = size
+		cfg.slide = slide
+	}
+}
+
+// NewWindowTimeOption triggers the window by time, checked on every RunOnce
+// tick: a tumbling window closes every length, a sliding window emits the
+// messages of the last length every slide. slide is ignored by tumbling
+// windows.
+func NewWindowTimeOption(length, slide time.Duration) WindowOption {
+	return func(cfg *windowConfig) {
+		if length <= 0 || slide < 0 {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		cfg.length = length
+		cfg.every = slide
+	}
+}
+
+// NewWindowGapOption closes a session window once no message arrived for
+// gap.
+func NewWindowGapOption(gap time.Duration) WindowOption {
+	return func(cfg *windowConfig) {
+		if gap <= 0 {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		cfg.gap = gap
+	}
+}
+
+// NewWindowValueOption sets how the value handed to the reducer is taken
+// from a message, by default the message must be a number.
+func NewWindowValueOption(fn func(msg any) (float64, error)) WindowOption {
+	return func(cfg *windowConfig) {
+		if fn == nil {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		cfg.value = fn
+	}
+}
+
+// NewWindowEmitOption hands all the closed windows to cb, those closed by
+// RunOnce and Flush included. It's called on the goroutine which closed the
+// window, Process or RunOnce, so it must be safe for concurrent use if the
+// flow processes messages concurrently.
+func NewWindowEmitOption(cb func(WindowResult)) WindowOption {
+	return func(cfg *windowConfig) {
+		if cb == nil {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		cfg.emit = cb
+	}
+}
+
+// NewWindowDownstreamOption hands the closed windows to df.Process, errors
+// are logged.
+func NewWindowDownstreamOption(df IDataFlow) WindowOption {
+	return func(cfg *windowConfig) {
+		if df == nil {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		cfg.emit = func(result WindowResult) {
+			_, err := df.Process(result)
+			if err != nil {
+				log.Printf("[E]downstream process window(%v) failed:%v\n", result.Key, err)
+			}
+		}
+	}
+}
+
+func windowValue(msg any) (float64, error) {
+	switch v := msg.(type) {
+	case int:
+		return float64(v), nil
+	case int8:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case uint8:
+		return float64(v), nil
+	case uint16:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	}
+	return 0, fmt.Errorf("msg(%T) isn't a number", msg)
+}
+
+type windowItem struct {
+	t time.Time
+	v float64
+}
+
+type window struct {
+	start time.Time
+	last  time.Time
+	// messages since the window opened, or since the last emission of a
+	// sliding window
+	count   int
+	reducer Reducer
+	// messages of a sliding window
+	items []windowItem
+}
+
+// WindowProcessor is a data processor aggregating the messages of a key
+// into windows. Process returns the WindowResult of the window the message
+// closed, so it reaches the next processors of the flow, and nil while the
+// window is still open, processors after it must let nil through. The
+// closed windows are also emitted to the callback or the data flow set by
+// NewWindowEmitOption or NewWindowDownstreamOption, which time triggered
+// and session windows need: they're closed by RunOnce, so the processor
+// must be registered into a running data flow.
+type WindowProcessor struct {
+	kind    WindowKind
+	cfg     windowConfig
+	key     func(msg any) any
+	reducer func() Reducer
+	now     func() time.Time
+
+	mux     sync.Mutex
+	windows map[any]*window
+	// last sliding boundary emitted
+	boundary time.Time
+}
+
+// NewWindowProcessor returns a processor of kind windows. key returns the
+// key of a message, which must be comparable, all messages share a window
+// if it's nil. reducer makes the reducer of every window.
+func NewWindowProcessor(kind WindowKind, key func(msg any) any, reducer func() Reducer, options ...WindowOption) (*WindowProcessor, error) {
+	if reducer == nil {
+		log.Printf("[E]invalid arg\n")
+		return nil, fmt.Errorf("invalid arg")
+	}
+	p := &WindowProcessor{
+		kind:    kind,
+		key:     key,
+		reducer: reducer,
+		now:     time.Now,
+		windows: make(map[any]*window),
+	}
+	p.cfg.value = windowValue
+	for _, v := range options {
+		v(&p.cfg)
+	}
+	if p.key == nil {
+		p.key = func(msg any) any { return nil }
+	}
+
+	byCount, byTime := p.cfg.size > 0, p.cfg.length > 0
+	switch kind {
+	case TumblingWindow, SlidingWindow:
+		if byCount == byTime || p.cfg.gap > 0 {
+			log.Printf("[E]%v window must be triggered either by count or by time\n", kind)
+			return nil, fmt.Errorf("%v window must be triggered either by count or by time", kind)
+		}
+		if kind == SlidingWindow && ((byCount && p.cfg.slide == 0) || (byTime && p.cfg.every == 0)) {
+			log.Printf("[E]sliding window needs a slide\n")
+			return nil, fmt.Errorf("sliding window needs a slide")
+		}
+	case SessionWindow:
+		if p.cfg.gap <= 0 || byCount || byTime {
+			log.Printf("[E]session window must be triggered by a gap\n")
+			return nil, fmt.Errorf("session window must be triggered by a gap")
+		}
+	default:
+		log.Printf("[E]unsupported window kind(%d)\n", kind)
+		return nil, fmt.Errorf("unsupported window kind(%d)", kind)
+	}
+	if p.cfg.emit == nil && (byTime || kind == SessionWindow) {
+		log.Printf("[E]%v window closed by RunOnce needs an emit option\n", kind)
+		return nil, fmt.Errorf("%v window closed by RunOnce needs an emit option", kind)
+	}
+	return p, nil
+}
+
+func (p *WindowProcessor) Init(args ...any) error {
+	return nil
+}
+
+// RunOnce closes the time triggered windows which are due.
+func (p *WindowProcessor) RunOnce(ctx context.Context) error {
+	now := p.now()
+	var closed []WindowResult
+	p.mux.Lock()
+	switch {
+	case p.kind == TumblingWindow && p.cfg.length > 0:
+		for k, w := range p.windows {
+			if end := w.start.Add(p.cfg.length); !now.Before(end) {
+				closed = append(closed, p.result(k, w, w.start, end))
+				delete(p.windows, k)
+			}
+		}
+	case p.kind == SlidingWindow && p.cfg.length > 0:
+		boundary := now.Truncate(p.cfg.every)
+		if boundary.After(p.boundary) {
+			p.boundary = boundary
+			closed = p.slide(boundary)
+		}
+	case p.kind == SessionWindow:
+		for k, w := range p.windows {
+			if now.Sub(w.last) >= p.cfg.gap {
+				closed = append(closed, p.result(k, w, w.start, w.last))
+				delete(p.windows, k)
+			}
+		}
+	}
+	p.mux.Unlock()
+	p.emit(closed)
+	return nil
+}
+
+// Destroy drops the open windows, call Flush first to emit them.
+func (p *WindowProcessor) Destroy() {
+	p.mux.Lock()
+	clear(p.windows)
+	p.mux.Unlock()
+}
+
+func (p *WindowProcessor) UserData() any {
+	return nil
+}
+
+func (p *WindowProcessor) MsgCheck(msg any) error {
+	_, err := p.cfg.value(msg)
+	return err
+}
+
+func (p *WindowProcessor) Process(msg any) (any, error) {
+	v, err := p.cfg.value(msg)
+	if err != nil {
+		return nil, err
+	}
+	k := p.key(msg)
+	now := p.now()
+	var closed []WindowResult
+
+	p.mux.Lock()
+	w := p.windows[k]
+	switch p.kind {
+	case TumblingWindow:
+		if p.cfg.size > 0 {
+			if w == nil {
+				w = p.open(k, now)
+			}
+			p.add(w, now, v)
+			if w.count >= p.cfg.size {
+				closed = append(closed, p.result(k, w, w.start, now))
+				delete(p.windows, k)
+			}
+			break
+		}
+		start := now.Truncate(p.cfg.length)
+		if w != nil && !w.start.Equal(start) {
+			// the tick didn't close it yet
+			closed = append(closed, p.result(k, w, w.start, w.start.Add(p.cfg.length)))
+			w = nil
+		}
+		if w == nil {
+			w = p.open(k, start)
+		}
+		p.add(w, now, v)
+	case SlidingWindow:
+		if w == nil {
+			w = p.open(k, now)
+		}
+		w.items = append(w.items, windowItem{t: now, v: v})
+		w.count++
+		if p.cfg.size > 0 {
+			if len(w.items) > p.cfg.size {
+				w.items = append(w.items[:0], w.items[len(w.items)-p.cfg.size:]...)
+			}
+			if w.count >= p.cfg.slide {
+				w.count = 0
+				closed = append(closed, p.reduce(k, w.items, w.items[0].t, now))
+			}
+			break
+		}
+		// older messages can't be in any later window
+		p.expire(w, now.Add(-p.cfg.length))
+	case SessionWindow:
+		if w != nil && now.Sub(w.last) >= p.cfg.gap {
+			closed = append(closed, p.result(k, w, w.start, w.last))
+			w = nil
+		}
+		if w == nil {
+			w = p.open(k, now)
+		}
+		p.add(w, now, v)
+	}
+	p.mux.Unlock()
+
+	p.emit(closed)
+	// a message closes its key's window only
+	if len(closed) == 0 {
+		return nil, nil
+	}
+	return closed[0], nil
+}
+
+// Flush closes all open windows, sliding windows emit the messages they
+// hold. The closed windows are returned as well.
+func (p *WindowProcessor) Flush() []WindowResult {
+	now := p.now()
+	var closed []WindowResult
+	p.mux.Lock()
+	for k, w := range p.windows {
+		if p.kind == SlidingWindow {
+			if len(w.items) > 0 {
+				closed = append(closed, p.reduce(k, w.items, w.items[0].t, now))
+			}
+		} else {
+			closed = append(closed, p.result(k, w, w.start, now))
+		}
+		delete(p.windows, k)
+	}
+	p.mux.Unlock()
+	p.emit(closed)
+	return closed
+}
+
+func (p *WindowProcessor) open(k any, start time.Time) *window {
+	w := &window{start: start}
+	if p.kind != SlidingWindow {
+		w.reducer = p.reducer()
+	}
+	p.windows[k] = w
+	return w
+}
+
+func (p *WindowProcessor) add(w *window, now time.Time, v float64) {
+	w.reducer.Add(v)
+	w.count++
+	w.last = now
+}
+
+func (p *WindowProcessor) result(k any, w *window, start, end time.Time) WindowResult {
+	return WindowResult{Key: k, Start: start, End: end, Count: w.count, Value: w.reducer.Result()}
+}
+
+func (p *WindowProcessor) reduce(k any, items []windowItem, start, end time.Time) WindowResult {
+	r := p.reducer()
+	for _, item := range items {
+		r.Add(item.v)
+	}
+	return WindowResult{Key: k, Start: start, End: end, Count: len(items), Value: r.Result()}
+}
+
+// expire drops the messages of w older than t.
+func (p *WindowProcessor) expire(w *window, t time.Time) {
+	i := 0
+	for i < len(w.items) && w.items[i].t.Before(t) {
+		i++
+	}
+	w.items = append(w.items[:0], w.items[i:]...)
+}
+
+// slide emits the time sliding windows ending at boundary.
+func (p *WindowProcessor) slide(boundary time.Time) []WindowResult {
+	var closed []WindowResult
+	start := boundary.Add(-p.cfg.length)
+	for k, w := range p.windows {
+		p.expire(w, start)
+		n := 0
+		for n < len(w.items) && w.items[n].t.Before(boundary) {
+			n++
+		}
+		if n > 0 {
+			closed = append(closed, p.reduce(k, w.items[:n], start, boundary))
+		}
+		// the next window starts a slide later
+		p.expire(w, start.Add(p.cfg.every))
+		if len(w.items) == 0 {
+			delete(p.windows, k)
+		}
+	}
+	return closed
+}
+
+func (p *WindowProcessor) emit(closed []WindowResult) {
+	if p.cfg.emit == nil {
+		return
+	}
+	for _, result := range closed {
+		p.cfg.emit(result)
+	}
+}
@@ -0,0 +1,301 @@
+package mrun
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type windowSink struct {
+	mux     sync.Mutex
+	results []WindowResult
+}
+
+func (s *windowSink) emit(result WindowResult) {
+	s.mux.Lock()
+	s.results = append(s.results, result)
+	s.mux.Unlock()
+}
+
+func (s *windowSink) take() []WindowResult {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	results := s.results
+	s.results = nil
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Key.(string) < results[j].Key.(string) })
+	return results
+}
+
+// fakeClock is the clock of a window processor under test
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestWindow(t *testing.T, kind WindowKind, reducer func() Reducer, options ...WindowOption) (*WindowProcessor, *windowSink, *fakeClock) {
+	t.Helper()
+	sink := &windowSink{}
+	options = append(options, NewWindowEmitOption(sink.emit), NewWindowValueOption(func(msg any) (float64, error) {
+		return windowValue(msg.([2]any)[1])
+	}))
+	p, err := NewWindowProcessor(kind, func(msg any) any { return msg.([2]any)[0] }, reducer, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	p.now = clock.now
+	return p, sink, clock
+}
+
+func TestTumblingWindowByCount(t *testing.T) {
+	p, sink, _ := newTestWindow(t, TumblingWindow, NewSumReducer, NewWindowCountOption(3, 0))
+	for i := 1; i <= 7; i++ {
+		if _, err := p.Process([2]any{"a", i}); err != nil {
+			t.Fatal(err)
+		}
+		p.Process([2]any{"b", 10 * i})
+	}
+	results := sink.take()
+	// a: 1+2+3, 4+5+6; b: 10+20+30, 40+50+60
+	want := map[string][]float64{"a": {6, 15}, "b": {60, 150}}
+	got := map[string][]float64{}
+	for _, r := range results {
+		if r.Count != 3 {
+			t.Fatalf("window of %d messages, want 3", r.Count)
+		}
+		got[r.Key.(string)] = append(got[r.Key.(string)], r.Value.(float64))
+	}
+	for k, v := range want {
+		if len(got[k]) != len(v) || got[k][0] != v[0] || got[k][1] != v[1] {
+			t.Fatalf("key %s got %v, want %v", k, got[k], v)
+		}
+	}
+
+	p.Flush()
+	results = sink.take()
+	if len(results) != 2 || results[0].Value != 7.0 || results[1].Value != 70.0 {
+		t.Fatalf("flushed %v, want the 7th messages", results)
+	}
+}
+
+func TestTumblingWindowByTime(t *testing.T) {
+	p, sink, clock := newTestWindow(t, TumblingWindow, NewCountReducer, NewWindowTimeOption(time.Second, 0))
+	p.Process([2]any{"a", 1})
+	clock.t = clock.t.Add(500 * time.Millisecond)
+	p.Process([2]any{"a", 1})
+	p.RunOnce(context.Background())
+	if results := sink.take(); len(results) != 0 {
+		t.Fatalf("window closed early: %v", results)
+	}
+
+	clock.t = clock.t.Add(500 * time.Millisecond)
+	p.RunOnce(context.Background())
+	results := sink.take()
+	if len(results) != 1 || results[0].Value != 2 || results[0].End.Sub(results[0].Start) != time.Second {
+		t.Fatalf("got %v, want one window of 2 messages", results)
+	}
+}
+
+func TestSlidingWindowByCount(t *testing.T) {
+	p, sink, _ := newTestWindow(t, SlidingWindow, NewMaxReducer, NewWindowCountOption(4, 2))
+	for _, v := range []int{5, 1, 9, 2, 3, 4, 1, 1} {
+		p.Process([2]any{"a", v})
+	}
+	var got []float64
+	for _, r := range sink.take() {
+		got = append(got, r.Value.(float64))
+	}
+	// windows [5 1], [5 1 9 2], [9 2 3 4], [3 4 1 1]
+	want := []float64{5, 9, 9, 4}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSlidingWindowByTime(t *testing.T) {
+	p, sink, clock := newTestWindow(t, SlidingWindow, NewSumReducer, NewWindowTimeOption(2*time.Second, time.Second))
+	p.RunOnce(context.Background())
+	for i := 1; i <= 3; i++ {
+		p.Process([2]any{"a", i})
+		clock.t = clock.t.Add(time.Second)
+		p.RunOnce(context.Background())
+	}
+	clock.t = clock.t.Add(time.Second)
+	p.RunOnce(context.Background())
+
+	var got []float64
+	for _, r := range sink.take() {
+		got = append(got, r.Value.(float64))
+	}
+	// windows of 2s every 1s: [1], [1 2], [2 3], [3]
+	want := []float64{1, 3, 5, 3}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	if len(p.windows) != 0 {
+		t.Fatalf("%d idle keys kept", len(p.windows))
+	}
+}
+
+func TestSessionWindow(t *testing.T) {
+	p, sink, clock := newTestWindow(t, SessionWindow, NewMinReducer, NewWindowGapOption(time.Second))
+	for _, v := range []int{4, 2, 7} {
+		p.Process([2]any{"a", v})
+		clock.t = clock.t.Add(900 * time.Millisecond)
+	}
+	p.RunOnce(context.Background())
+	if results := sink.take(); len(results) != 0 {
+		t.Fatalf("session closed early: %v", results)
+	}
+	clock.t = clock.t.Add(100 * time.Millisecond)
+	p.RunOnce(context.Background())
+	results := sink.take()
+	if len(results) != 1 || results[0].Count != 3 || results[0].Value != 2.0 {
+		t.Fatalf("got %v, want one session of 3 messages with min 2", results)
+	}
+}
+
+func TestPercentilesReducer(t *testing.T) {
+	r := PercentilesReducer(0.5, 0.99)()
+	for i := 100; i >= 1; i-- {
+		r.Add(float64(i))
+	}
+	ps := r.Result().([]float64)
+	if len(ps) != 2 || ps[0] != 50.5 || ps[1] < 99 {
+		t.Fatalf("got percentiles %v", ps)
+	}
+
+	// fractions aren't truncated
+	r = PercentilesReducer(0.5)()
+	for _, v := range []float64{-0.25, 0.25, 0.75} {
+		r.Add(v)
+	}
+	if ps = r.Result().([]float64); ps[0] != 0.25 {
+		t.Fatalf("got median %v, want 0.25", ps[0])
+	}
+}
+
+// resultCollector is a processor after a window processor, it lets nil
+// through.
+type resultCollector struct {
+	funcProcessor
+	values []any
+}
+
+func (p *resultCollector) MsgCheck(msg any) error {
+	return nil
+}
+
+func (p *resultCollector) Process(msg any) (any, error) {
+	if result, ok := msg.(WindowResult); ok {
+		p.values = append(p.values, result.Value)
+	}
+	return msg, nil
+}
+
+func TestWindowProcessorResultsDownstream(t *testing.T) {
+	// no emit option, the closed windows go to the next processor
+	p, err := NewWindowProcessor(TumblingWindow, nil, NewSumReducer, NewWindowCountOption(2, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := &resultCollector{}
+	df := &SequenceDataFlow{}
+	df.Register(p, []DataFlowOption{NewDataFlowOrderOption(0)})
+	df.Register(next, []DataFlowOption{NewDataFlowOrderOption(1)})
+	if err = df.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer df.Destroy()
+
+	for i := 1; i <= 5; i++ {
+		out, err := df.Process(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (out == nil) != (i%2 == 1) {
+			t.Fatalf("Process(%d) = %v", i, out)
+		}
+	}
+	if sums := next.values; len(sums) != 2 || sums[0] != 3.0 || sums[1] != 7.0 {
+		t.Fatalf("downstream got %v, want [3 7]", sums)
+	}
+	if flushed := p.Flush(); len(flushed) != 1 || flushed[0].Value != 5.0 {
+		t.Fatalf("flushed %v, want the 5th message", flushed)
+	}
+}
+
+func TestWindowProcessorInFlow(t *testing.T) {
+	sink := &windowSink{}
+	p, err := NewWindowProcessor(TumblingWindow, nil, NewCountReducer, NewWindowTimeOption(20*time.Millisecond, 0), NewWindowEmitOption(sink.emit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	df := &SequenceDataFlow{}
+	if err = df.Register(p, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = df.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer df.Destroy()
+
+	for range 5 {
+		if _, err := df.Process(1); err != nil {
+			t.Fatalf("Process(1) failed:%v", err)
+		}
+	}
+	if _, err = df.Process("x"); err == nil {
+		t.Fatal("non numeric message accepted")
+	}
+	// closed by the RunOnce tick
+	deadline := time.Now().Add(time.Second)
+	count := 0
+	for count < 5 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		sink.mux.Lock()
+		count = 0
+		for _, r := range sink.results {
+			count += r.Value.(int)
+		}
+		sink.mux.Unlock()
+	}
+	if count != 5 {
+		t.Fatalf("counted %d messages, want 5", count)
+	}
+}
+
+func TestNewWindowProcessorInvalid(t *testing.T) {
+	emit := NewWindowEmitOption(func(WindowResult) {})
+	cases := []struct {
+		kind    WindowKind
+		options []WindowOption
+	}{
+		{TumblingWindow, []WindowOption{emit}},
+		{TumblingWindow, []WindowOption{emit, NewWindowCountOption(2, 0), NewWindowTimeOption(time.Second, 0)}},
+		{SlidingWindow, []WindowOption{emit, NewWindowCountOption(2, 0)}},
+		{SessionWindow, []WindowOption{emit, NewWindowCountOption(2, 0)}},
+		{TumblingWindow, []WindowOption{NewWindowTimeOption(time.Second, 0)}},
+		{SessionWindow, []WindowOption{NewWindowGapOption(time.Second)}},
+	}
+	for i, c := range cases {
+		if _, err := NewWindowProcessor(c.kind, nil, NewCountReducer, c.options...); err == nil {
+			t.Fatalf("case %d: invalid %v window accepted", i, c.kind)
+		}
+	}
+}
@@ -139,8 +139,8 @@ func main() {
 			return
 		} else {
 			fmt.Printf("parallel DataFlow Process(%d)=%#v\n", iLoop, ret)
-			if _, ok := ret.([]interface{}); !ok {
-				fmt.Printf("ret(%#v) convert to []int failed\n", ret)
+			if _, ok := ret.([]mrun.DataFlowResult); !ok {
+				fmt.Printf("ret(%#v) convert to []mrun.DataFlowResult failed\n", ret)
 			}
 		}
 	}
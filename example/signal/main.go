@@ -137,7 +137,7 @@ func main() {
 		return
 	}
 	msys.msgSig = msgSig
-	err = mrun.Connect(msgSig, msys.F1)
+	_, err = mrun.Connect(msgSig, msys.F1)
 	if err != nil {
 		fmt.Println("connect signal failed:", err)
 		return
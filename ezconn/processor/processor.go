@@ -7,7 +7,7 @@ import (
 	"reflect"
 	"sync"
 
-	"mlib.com/mrun/ezconn"
+	"github.com/odysseythink/mrun/ezconn"
 )
 
 type MsgInfo struct {
@@ -0,0 +1,279 @@
+package ezconn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+
+	"github.com/odysseythink/mrun"
+)
+
+const (
+	// DEFAULT_SIGNAL_BRIDGE_HEADERID is the header id of the messages of a
+	// SignalBridge, it must not collide with the other messages of its
+	// processor.
+	DEFAULT_SIGNAL_BRIDGE_HEADERID uint32 = 0x7f5e0001
+	// the limits a SignalBridge creates its communicator with, see
+	// NewSignalBridgeConnOption
+	DEFAULT_SIGNAL_BRIDGE_MAX_CONN_NUM      = 100
+	DEFAULT_SIGNAL_BRIDGE_PENDING_WRITE_NUM = 100
+	DEFAULT_SIGNAL_BRIDGE_THREAD_NUM        = 50
+)
+
+// SignalMsg is the message a SignalBridge sends for an emission: the name of
+// the signal and its args, each encoded as JSON.
+type SignalMsg struct {
+	Name string
+	Args []json.RawMessage
+}
+
+// handlerRegistrar is implemented by the processors of ezconn/processor, the
+// bridge registers its message with it.
+type handlerRegistrar interface {
+	RegisterHandler(headerid uint32, msg interface{}, handler func(conn IConn, req interface{})) error
+}
+
+type SignalBridgeOption func(*SignalBridge) error
+
+// NewSignalBridgeHeaderIDOption sets the header id of the bridge messages,
+// DEFAULT_SIGNAL_BRIDGE_HEADERID by default.
+func NewSignalBridgeHeaderIDOption(headerid uint32) func(*SignalBridge) error {
+	return func(b *SignalBridge) error {
+		b.headerid = headerid
+		return nil
+	}
+}
+
+// NewSignalBridgeConnOption sets the limits handed to NewCommunicator: the
+// max number of connections, of pending writes per connection and of
+// threads.
+func NewSignalBridgeConnOption(maxConnNum, pendingWriteNum, threadnum int) func(*SignalBridge) error {
+	return func(b *SignalBridge) error {
+		if maxConnNum <= 0 || pendingWriteNum <= 0 || threadnum <= 0 {
+			log.Printf("[E]invalid arg\n")
+			return errors.New("invalid arg")
+		}
+		b.maxConnNum = maxConnNum
+		b.pendingWriteNum = pendingWriteNum
+		b.threadnum = threadnum
+		return nil
+	}
+}
+
+// NewSignalBridgeMessageOption makes the bridge send msg, a pointer to a
+// message its processor can marshal, instead of SignalMsg, e.g. a protobuf
+// message for a ProtobufProcessor. wrap fills a new message from a
+// SignalMsg, unwrap does the opposite.
+func NewSignalBridgeMessageOption(msg interface{}, wrap func(m *SignalMsg) interface{}, unwrap func(msg interface{}) (*SignalMsg, error)) func(*SignalBridge) error {
+	return func(b *SignalBridge) error {
+		if msg == nil || wrap == nil || unwrap == nil {
+			log.Printf("[E]invalid arg\n")
+			return errors.New("invalid arg")
+		}
+		b.msg = msg
+		b.wrap = wrap
+		b.unwrap = unwrap
+		return nil
+	}
+}
+
+// SignalBridge forwards the emissions of mrun signals to other processes
+// over an ezconn communicator, and emits the ones it receives to the slots
+// connected to it by signal name.
+//
+// Delivery is at-most-once: an emission is sent once and never
+// acknowledged nor retried, so it's lost if the datagram or the connection
+// is, if no slot is connected to it on the receiving side, if its args
+// can't be decoded, or if a signal queue drops it. It's never delivered
+// twice. A message must fit the processor's max package size.
+//
+// Received emissions go to the untyped signals of their name only, the
+// typed ones (mrun.Signal0 to Signal3) can be exported but can't receive:
+// an emission named after one is dropped with an error log.
+type SignalBridge struct {
+	app      *mrun.App
+	comm     ICommunicator
+	headerid uint32
+	msg      interface{}
+	wrap     func(m *SignalMsg) interface{}
+	unwrap   func(msg interface{}) (*SignalMsg, error)
+
+	maxConnNum      int
+	pendingWriteNum int
+	threadnum       int
+
+	mux     sync.Mutex
+	exports []*mrun.Subscription
+	closed  bool
+}
+
+// NewSignalBridge returns a bridge for the signals of app, the default App
+// if it's nil, communicating over protocol (tcpserver, tcpclient or udp) on
+// addr. processor must be one of ezconn/processor, the bridge registers its
+// message with it, JSON by default.
+func NewSignalBridge(app *mrun.App, protocol, addr string, processor IProcessor, options ...SignalBridgeOption) (*SignalBridge, error) {
+	registrar, ok := processor.(handlerRegistrar)
+	if !ok {
+		log.Printf("[E]processor(%T) can't register handlers\n", processor)
+		return nil, fmt.Errorf("processor(%T) can't register handlers", processor)
+	}
+	if app == nil {
+		app = mrun.DefaultApp()
+	}
+	b := &SignalBridge{
+		app:      app,
+		headerid: DEFAULT_SIGNAL_BRIDGE_HEADERID,
+		msg:      &SignalMsg{},
+		wrap: func(m *SignalMsg) interface{} {
+			return m
+		},
+		unwrap: func(msg interface{}) (*SignalMsg, error) {
+			m, ok := msg.(*SignalMsg)
+			if !ok || m == nil {
+				return nil, fmt.Errorf("msg(%T) isn't a SignalMsg", msg)
+			}
+			return m, nil
+		},
+		maxConnNum:      DEFAULT_SIGNAL_BRIDGE_MAX_CONN_NUM,
+		pendingWriteNum: DEFAULT_SIGNAL_BRIDGE_PENDING_WRITE_NUM,
+		threadnum:       DEFAULT_SIGNAL_BRIDGE_THREAD_NUM,
+	}
+	for _, option := range options {
+		err := option(b)
+		if err != nil {
+			log.Printf("[E]run signal bridge option func failed:%v\n", err)
+			return nil, err
+		}
+	}
+
+	err := registrar.RegisterHandler(b.headerid, b.msg, b.onMsg)
+	if err != nil {
+		log.Printf("[E]register signal bridge message failed:%v\n", err)
+		return nil, err
+	}
+	b.comm = NewCommunicator(protocol, addr, b.maxConnNum, b.pendingWriteNum, b.threadnum, processor)
+	if b.comm == nil {
+		log.Printf("[E]new %s communicator on %s failed\n", protocol, addr)
+		return nil, fmt.Errorf("new %s communicator on %s failed", protocol, addr)
+	}
+	return b, nil
+}
+
+// Export forwards the emissions of the local signals matching pattern, see
+// mrun.Subscribe, to remote. The args must be JSON encodable. options apply
+// to the local connections, a queued delivery keeps the network off the
+// emitting goroutine. remote is ignored by a tcpclient communicator, which
+// has a single server. A pattern matching the signals of Connect sends the
+// received emissions back.
+func (b *SignalBridge) Export(pattern, remote string, options ...mrun.ConnectOption) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.closed {
+		log.Printf("[E]signal bridge closed\n")
+		return errors.New("signal bridge closed")
+	}
+	sub, err := b.app.Subscribe(pattern, func(name string, args []any) {
+		b.send(remote, name, args)
+	}, options...)
+	if err != nil {
+		return err
+	}
+	b.exports = append(b.exports, sub)
+	return nil
+}
+
+func (b *SignalBridge) send(remote, name string, args []any) {
+	m := &SignalMsg{Name: name, Args: make([]json.RawMessage, 0, len(args))}
+	for idx, arg := range args {
+		data, err := json.Marshal(arg)
+		if err != nil {
+			log.Printf("[E]encode signal(%s) argument[%d] failed:%v\n", name, idx, err)
+			return
+		}
+		m.Args = append(m.Args, data)
+	}
+	err := b.comm.SendToRemote(remote, b.wrap(m))
+	if err != nil {
+		log.Printf("[E]send signal(%s) to %s failed:%v\n", name, remote, err)
+	}
+}
+
+// Connect connects slot to the emissions named name received by the bridge.
+// The bridge emits them, queued, to a local signal of that name which is
+// created with the type of slot unless it exists, the slots of a signal
+// must share its type, and a typed signal of that name can't take them.
+// The returned Connection disconnects slot.
+func (b *SignalBridge) Connect(name string, slot any, options ...mrun.ConnectOption) (*mrun.Connection, error) {
+	s, ok := b.app.LookupSignal(name)
+	if !ok {
+		var err error
+		s, err = b.app.NewSignal(name, slot)
+		if err != nil {
+			// created meanwhile
+			if s, ok = b.app.LookupSignal(name); !ok {
+				return nil, err
+			}
+		}
+	}
+	return mrun.Connect(s, slot, options...)
+}
+
+// onMsg emits a received message to the local signal of its name.
+func (b *SignalBridge) onMsg(conn IConn, req interface{}) {
+	m, err := b.unwrap(req)
+	if err != nil {
+		log.Printf("[E]invalid signal bridge message from %s:%v\n", conn.RemoteAddr(), err)
+		return
+	}
+	s, ok := b.app.LookupSignal(m.Name)
+	if !ok {
+		for _, info := range b.app.Signals() {
+			if info.Name == m.Name {
+				log.Printf("[E]signal(%s) from %s dropped, typed signals can't receive bridged emissions\n", m.Name, conn.RemoteAddr())
+				return
+			}
+		}
+		log.Printf("[D]no slot for signal(%s) from %s\n", m.Name, conn.RemoteAddr())
+		return
+	}
+	params := s.ParamTypes()
+	if len(params) != len(m.Args) {
+		log.Printf("[E]signal(%s) from %s has %d args, want %d\n", m.Name, conn.RemoteAddr(), len(m.Args), len(params))
+		return
+	}
+	args := make([]any, 0, len(params))
+	for idx, t := range params {
+		v := reflect.New(t)
+		err = json.Unmarshal(m.Args[idx], v.Interface())
+		if err != nil {
+			log.Printf("[E]decode signal(%s) argument[%d] failed:%v\n", m.Name, idx, err)
+			return
+		}
+		args = append(args, v.Elem().Interface())
+	}
+	err = s.Emit(args...)
+	if err != nil {
+		log.Printf("[E]emit signal(%s) from %s failed:%v\n", m.Name, conn.RemoteAddr(), err)
+	}
+}
+
+// Close stops forwarding the exported signals and closes the communicator.
+// The slots connected by Connect stay connected to their local signals.
+func (b *SignalBridge) Close() {
+	b.mux.Lock()
+	if b.closed {
+		b.mux.Unlock()
+		return
+	}
+	b.closed = true
+	exports := b.exports
+	b.exports = nil
+	b.mux.Unlock()
+	for _, sub := range exports {
+		sub.Unsubscribe()
+	}
+	b.comm.Close()
+}
@@ -0,0 +1,88 @@
+package ezconn_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/odysseythink/mrun"
+	"github.com/odysseythink/mrun/ezconn"
+	"github.com/odysseythink/mrun/ezconn/processor"
+)
+
+// freeUDPAddr returns a loopback address no one listens on.
+func freeUDPAddr(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+	return addr
+}
+
+func newTestBridge(t *testing.T, name, addr string) (*mrun.App, *ezconn.SignalBridge) {
+	t.Helper()
+	app, err := mrun.NewApp(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ezconn.NewSignalBridge(app, "udp", addr, &processor.JsonProcessor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+	return app, b
+}
+
+type reading struct {
+	id   string
+	temp float64
+}
+
+func TestSignalBridgeLoopback(t *testing.T) {
+	senderAddr, receiverAddr := freeUDPAddr(t), freeUDPAddr(t)
+	sender, senderBridge := newTestBridge(t, "sender", senderAddr)
+	_, receiverBridge := newTestBridge(t, "receiver", receiverAddr)
+
+	temp, err := sender.NewSignal("sensor.temp", func(id string, temp float64) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	direct := mrun.NewConnectDeliveryOption(mrun.DeliveryDirect)
+	if err = senderBridge.Export("sensor.#", receiverAddr, direct); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan reading, 1)
+	_, err = receiverBridge.Connect("sensor.temp", func(id string, temp float64) {
+		got <- reading{id, temp}
+	}, direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = temp.EmitDirect("kitchen", 21.5); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-got:
+		if r.id != "kitchen" || r.temp != 21.5 {
+			t.Fatalf("received %+v, want {kitchen 21.5}", r)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("emission not received by the remote bridge")
+	}
+
+	// nothing is forwarded once the bridge is closed
+	senderBridge.Close()
+	if err = senderBridge.Export("sensor.#", receiverAddr); err == nil {
+		t.Fatal("Export on a closed bridge succeeded")
+	}
+	temp.EmitDirect("kitchen", 22.0)
+	select {
+	case r := <-got:
+		t.Fatalf("received %+v after close", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
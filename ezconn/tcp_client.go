@@ -10,7 +10,7 @@ import (
 	"sync"
 	"time"
 
-	"mlib.com/mrun"
+	"github.com/odysseythink/mrun"
 )
 
 type TCPClient struct {
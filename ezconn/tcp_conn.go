@@ -9,8 +9,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/odysseythink/mrun"
 	"github.com/odysseythink/mrun/fleets"
-	"mlib.com/mrun"
 )
 
 type tcpConnIOBase struct {
@@ -7,7 +7,7 @@ import (
 	"sync"
 	"time"
 
-	"mlib.com/mrun"
+	"github.com/odysseythink/mrun"
 )
 
 type TCPServer struct {
@@ -10,8 +10,8 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/odysseythink/mrun"
 	"github.com/odysseythink/mrun/fleets"
-	"mlib.com/mrun"
 )
 
 type UDPCommunicator struct {
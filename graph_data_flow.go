@@ -0,0 +1,357 @@
+package mrun
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// NewDataFlowNameOption names a data processor, the graph data flow refers
+// to its nodes by name.
+func NewDataFlowNameOption(name string) func(info *dataProcessorInfo) {
+	return func(info *dataProcessorInfo) {
+		if name == "" {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		info.name = name
+	}
+}
+
+type graphEdge struct {
+	name string
+	from string
+	to   string
+	pred func(msg any) bool
+}
+
+// GraphDataFlow is a data flow whose processors are the nodes of a directed
+// acyclic graph. A message is handed to the nodes without incoming edges,
+// then every node's output follows the outgoing edges whose predicate
+// accepts it. A node runs only if at least one message reached it, it gets
+// the message as is if a single edge delivered one, else the []any of the
+// delivered messages in the order the edges were added. Nodes which aren't
+// reached are skipped along with the nodes only they feed.
+//
+// Process returns a map[string]any of the outputs of the nodes without
+// outgoing edges which ran, by node name. Nodes and edges must be added
+// before Init, which checks the graph is acyclic. A node unregistered after
+// Init, e.g. once its RunOnce failed, is skipped along with the nodes only it
+// feeds.
+type GraphDataFlow struct {
+	BaseDataFlow
+	edgesMux sync.RWMutex
+	edges    []*graphEdge
+	// the graph of the last chain a call ran on
+	topology atomic.Pointer[graphTopology]
+}
+
+// graphTopology is the graph of the nodes of a chain, sorted topologically.
+type graphTopology struct {
+	chain  *processorChain
+	sorted []*dataProcessorInfo
+	inputs map[string][]*graphEdge
+	output map[string][]*graphEdge
+}
+
+func (df *GraphDataFlow) Register(p IDataProcessor, options []DataFlowOption, args ...any) error {
+	if p == nil {
+		log.Printf("[E]invalid arg\n")
+		return fmt.Errorf("invalid arg")
+	}
+	if df.ctx != nil {
+		log.Printf("[E]graph already started\n")
+		return fmt.Errorf("graph already started")
+	}
+	if df.Contains(p) {
+		log.Printf("[E]already register\n")
+		return fmt.Errorf("already register")
+	}
+
+	info := &dataProcessorInfo{
+		p:      p,
+		exitCh: make(chan struct{}),
+		order:  999,
+	}
+	if args != nil {
+		info.args = make([]any, 0)
+		info.args = append(info.args, args...)
+	}
+	for _, v := range options {
+		v(info)
+	}
+	if info.name == "" {
+		log.Printf("[E]graph node must be named by NewDataFlowNameOption\n")
+		return fmt.Errorf("graph node must be named by NewDataFlowNameOption")
+	}
+	if df.getNode(info.name) != nil {
+		log.Printf("[E]node(%s) already register\n", info.name)
+		return fmt.Errorf("node(%s) already register", info.name)
+	}
+	df.addDataProcessor(info)
+	return nil
+}
+
+func (df *GraphDataFlow) getNode(name string) *dataProcessorInfo {
+	df.processorsMux.RLock()
+	defer df.processorsMux.RUnlock()
+	if df.processors == nil {
+		return nil
+	}
+	for e := df.processors.Front(); e != nil; e = e.Next() {
+		if e.Value.(*dataProcessorInfo).name == name {
+			return e.Value.(*dataProcessorInfo)
+		}
+	}
+	return nil
+}
+
+// AddEdge adds an edge named name from node from to node to. A nil pred
+// passes every message, else only those it returns true for. The nodes may
+// be registered later, they're checked by Init.
+func (df *GraphDataFlow) AddEdge(name, from, to string, pred func(msg any) bool) error {
+	if name == "" || from == "" || to == "" {
+		log.Printf("[E]invalid arg\n")
+		return fmt.Errorf("invalid arg")
+	}
+	if df.ctx != nil {
+		log.Printf("[E]graph already started\n")
+		return fmt.Errorf("graph already started")
+	}
+	df.edgesMux.Lock()
+	defer df.edgesMux.Unlock()
+	for _, e := range df.edges {
+		if e.name == name {
+			log.Printf("[E]edge(%s) already exist\n", name)
+			return fmt.Errorf("edge(%s) already exist", name)
+		}
+	}
+	df.edges = append(df.edges, &graphEdge{name: name, from: from, to: to, pred: pred})
+	return nil
+}
+
+func (df *GraphDataFlow) Init() error {
+	_, err := df.build(df.snapshot(), true)
+	if err != nil {
+		log.Printf("[E]check graph failed:%v\n", err)
+		return err
+	}
+	return df.BaseDataFlow.Init()
+}
+
+// build sorts nodes topologically. If strict, edges must refer to nodes,
+// else an edge from a missing node never delivers and an edge to a missing
+// node delivers nowhere, nodes can only be missing once unregistered.
+func (df *GraphDataFlow) build(nodes []*dataProcessorInfo, strict bool) (*graphTopology, error) {
+	byName := make(map[string]*dataProcessorInfo, len(nodes))
+	for _, node := range nodes {
+		byName[node.name] = node
+	}
+
+	inputs := make(map[string][]*graphEdge)
+	output := make(map[string][]*graphEdge)
+	df.edgesMux.RLock()
+	pending := make(map[string]int, len(nodes))
+	for _, e := range df.edges {
+		for _, name := range []string{e.from, e.to} {
+			if strict && byName[name] == nil {
+				df.edgesMux.RUnlock()
+				return nil, fmt.Errorf("edge(%s) refers to missing node(%s)", e.name, name)
+			}
+		}
+		if byName[e.from] != nil {
+			output[e.from] = append(output[e.from], e)
+		}
+		if byName[e.to] == nil {
+			continue
+		}
+		inputs[e.to] = append(inputs[e.to], e)
+		if byName[e.from] != nil {
+			pending[e.to]++
+		}
+	}
+	df.edgesMux.RUnlock()
+
+	sorted := make([]*dataProcessorInfo, 0, len(nodes))
+	done := make(map[string]bool, len(nodes))
+	for len(sorted) < len(nodes) {
+		var next *dataProcessorInfo
+		for _, node := range nodes {
+			if !done[node.name] && pending[node.name] == 0 {
+				next = node
+				break
+			}
+		}
+		if next == nil {
+			cycle := make([]string, 0)
+			for _, node := range nodes {
+				if !done[node.name] {
+					cycle = append(cycle, node.name)
+				}
+			}
+			return nil, fmt.Errorf("cycle between nodes(%s)", strings.Join(cycle, ","))
+		}
+		done[next.name] = true
+		sorted = append(sorted, next)
+		for _, e := range output[next.name] {
+			pending[e.to]--
+		}
+	}
+	return &graphTopology{sorted: sorted, inputs: inputs, output: output}, nil
+}
+
+// graphOf returns the graph of the nodes of c, built once per chain.
+func (df *GraphDataFlow) graphOf(c *processorChain) (*graphTopology, error) {
+	t := df.topology.Load()
+	if t != nil && t.chain == c {
+		return t, nil
+	}
+	t, err := df.build(c.processors(), false)
+	if err != nil {
+		return nil, err
+	}
+	t.chain = c
+	df.topology.Store(t)
+	return t, nil
+}
+
+func (df *GraphDataFlow) Process(msg any) (any, error) {
+	return df.ProcessContext(df.context(), msg)
+}
+
+// ProcessContext is like Process, ctx is checked before each node.
+func (df *GraphDataFlow) ProcessContext(ctx context.Context, msg any) (ret any, err error) {
+	if df.ctx == nil {
+		log.Printf("[E]graph not started\n")
+		return nil, fmt.Errorf("graph not started")
+	}
+	ctx, s := df.startSpan(ctx, "graph")
+	defer func() {
+		s.endWith(err)
+	}()
+	c := df.acquire()
+	defer df.release(c)
+	t, err := df.graphOf(c)
+	if err != nil {
+		return nil, err
+	}
+	// messages delivered by each edge
+	delivered := make(map[*graphEdge]any)
+	outputs := make(map[string]any)
+	for _, node := range t.sorted {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var in any
+		edges := t.inputs[node.name]
+		if len(edges) == 0 {
+			in = msg
+		} else {
+			var msgs []any
+			for _, e := range edges {
+				if v, ok := delivered[e]; ok {
+					msgs = append(msgs, v)
+				}
+			}
+			if len(msgs) == 0 {
+				continue
+			}
+			in = msgs[0]
+			if len(msgs) > 1 {
+				in = msgs
+			}
+		}
+
+		out, err := df.call(ctx, node, in)
+		if err != nil {
+			log.Printf("[E]node(%s) process failed:%v\n", node.name, err)
+			return nil, fmt.Errorf("node(%s) process failed:%w", node.name, err)
+		}
+		if len(t.output[node.name]) == 0 {
+			outputs[node.name] = out
+			continue
+		}
+		for _, e := range t.output[node.name] {
+			if e.pred == nil || e.pred(out) {
+				delivered[e] = out
+			}
+		}
+	}
+	return outputs, nil
+}
+
+// call runs a node, the ProcessFaild callback may recover its failure.
+func (df *GraphDataFlow) call(ctx context.Context, node *dataProcessorInfo, msg any) (any, error) {
+	out, err := df.runProcessor(ctx, node, msg)
+	if err != nil {
+		return node.failed(msg, err)
+	}
+	return out, nil
+}
+
+func (df *GraphDataFlow) graph() ([]string, []*graphEdge) {
+	var nodes []string
+	df.processorsMux.RLock()
+	if df.processors != nil {
+		for e := df.processors.Front(); e != nil; e = e.Next() {
+			nodes = append(nodes, e.Value.(*dataProcessorInfo).name)
+		}
+	}
+	df.processorsMux.RUnlock()
+	df.edgesMux.RLock()
+	edges := append([]*graphEdge(nil), df.edges...)
+	df.edgesMux.RUnlock()
+	return nodes, edges
+}
+
+// DOT exports the graph in the Graphviz DOT language. Conditional edges are
+// dashed.
+func (df *GraphDataFlow) DOT() string {
+	nodes, edges := df.graph()
+	var b strings.Builder
+	b.WriteString("digraph dataflow {\n")
+	for _, name := range nodes {
+		fmt.Fprintf(&b, "\t%q;\n", name)
+	}
+	for _, e := range edges {
+		style := ""
+		if e.pred != nil {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "\t%q -> %q [label=%q%s];\n", e.from, e.to, e.name, style)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid exports the graph as a Mermaid flowchart. Conditional edges are
+// dotted.
+func (df *GraphDataFlow) Mermaid() string {
+	nodes, edges := df.graph()
+	ids := make(map[string]string, len(nodes))
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for i, name := range nodes {
+		ids[name] = fmt.Sprintf("n%d", i)
+		fmt.Fprintf(&b, "\t%s[\"%s\"]\n", ids[name], mermaidEscape(name))
+	}
+	for _, e := range edges {
+		from, to := ids[e.from], ids[e.to]
+		if from == "" || to == "" {
+			continue
+		}
+		arrow := "-->"
+		if e.pred != nil {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(&b, "\t%s %s|\"%s\"| %s\n", from, arrow, mermaidEscape(e.name), to)
+	}
+	return b.String()
+}
+
+func mermaidEscape(s string) string {
+	return strings.ReplaceAll(s, `"`, "#quot;")
+}
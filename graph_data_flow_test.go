@@ -0,0 +1,217 @@
+package mrun
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// anyProcessor accepts any message, the merge nodes get a []any
+type anyProcessor struct {
+	funcProcessor
+}
+
+func (p *anyProcessor) MsgCheck(msg any) error {
+	return nil
+}
+
+func newTestGraph(t *testing.T, nodes map[string]func(msg any) (any, error)) *GraphDataFlow {
+	t.Helper()
+	df := NewDataFlow("graph").(*GraphDataFlow)
+	for name, fn := range nodes {
+		err := df.Register(&anyProcessor{funcProcessor{fn: fn}}, []DataFlowOption{NewDataFlowNameOption(name)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return df
+}
+
+func TestGraphDataFlowRouting(t *testing.T) {
+	id := func(msg any) (any, error) { return msg, nil }
+	df := newTestGraph(t, map[string]func(msg any) (any, error){
+		"decode": id,
+		"small":  func(msg any) (any, error) { return msg.(int) * 10, nil },
+		"big":    func(msg any) (any, error) { return msg.(int) * 100, nil },
+		"merge": func(msg any) (any, error) {
+			if msgs, ok := msg.([]any); ok {
+				return len(msgs), nil
+			}
+			return msg, nil
+		},
+	})
+	df.AddEdge("small", "decode", "small", func(msg any) bool { return msg.(int) < 10 })
+	df.AddEdge("big", "decode", "big", func(msg any) bool { return msg.(int) >= 5 })
+	df.AddEdge("from-small", "small", "merge", nil)
+	df.AddEdge("from-big", "big", "merge", nil)
+	if err := df.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer df.Destroy()
+
+	for _, c := range []struct {
+		in   int
+		want any
+	}{
+		{1, 10},    // only small
+		{20, 2000}, // only big
+		{7, 2},     // both branches merged
+	} {
+		out, err := df.Process(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := out.(map[string]any)["merge"]; got != c.want {
+			t.Fatalf("Process(%d) got %v, want %v", c.in, got, c.want)
+		}
+	}
+
+	// a failing node aborts the call
+	fail := errors.New("fail")
+	df2 := newTestGraph(t, map[string]func(msg any) (any, error){
+		"a": func(msg any) (any, error) { return nil, fail },
+		"b": id,
+	})
+	df2.AddEdge("ab", "a", "b", nil)
+	df2.Init()
+	defer df2.Destroy()
+	if _, err := df2.Process(1); !errors.Is(err, fail) {
+		t.Fatalf("got %v, want %v", err, fail)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := df2.ProcessContext(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestGraphDataFlowCheck(t *testing.T) {
+	id := func(msg any) (any, error) { return msg, nil }
+	df := newTestGraph(t, map[string]func(msg any) (any, error){"a": id, "b": id, "c": id})
+	df.AddEdge("ab", "a", "b", nil)
+	df.AddEdge("bc", "b", "c", nil)
+	df.AddEdge("ca", "c", "a", nil)
+	if err := df.Init(); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("cycle not detected:%v", err)
+	}
+
+	df2 := newTestGraph(t, map[string]func(msg any) (any, error){"a": id})
+	df2.AddEdge("ax", "a", "x", nil)
+	if err := df2.Init(); err == nil {
+		t.Fatal("missing node not detected")
+	}
+	if err := df2.Register(&funcProcessor{fn: id}, nil); err == nil {
+		t.Fatal("unnamed node accepted")
+	}
+	if err := df2.AddEdge("ax", "a", "b", nil); err == nil {
+		t.Fatal("duplicated edge accepted")
+	}
+}
+
+// brokenNode fails its first RunOnce, the flow unregisters it
+type brokenNode struct {
+	anyProcessor
+	destroyed atomic.Bool
+}
+
+func (p *brokenNode) RunOnce(ctx context.Context) error {
+	return errors.New("broken")
+}
+
+func (p *brokenNode) Destroy() {
+	p.destroyed.Store(true)
+}
+
+func (p *brokenNode) Process(msg any) (any, error) {
+	if p.destroyed.Load() {
+		return nil, errors.New("destroyed node called")
+	}
+	return msg, nil
+}
+
+func TestGraphDataFlowUnregisteredNode(t *testing.T) {
+	id := func(msg any) (any, error) { return msg, nil }
+	df := newTestGraph(t, map[string]func(msg any) (any, error){"decode": id, "persist": id, "audit": id})
+	broken := &brokenNode{}
+	df.Register(broken, []DataFlowOption{NewDataFlowNameOption("enrich")})
+	df.AddEdge("enrich", "decode", "enrich", nil)
+	df.AddEdge("persist", "enrich", "persist", nil)
+	df.AddEdge("audit", "decode", "audit", nil)
+	if err := df.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer df.Destroy()
+
+	deadline := time.Now().Add(time.Second)
+	for df.Contains(broken) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if df.Contains(broken) {
+		t.Fatal("node with a failed RunOnce still registered")
+	}
+	// persist is only fed by enrich
+	out, err := df.Process(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := out.(map[string]any)
+	if _, ok := outputs["persist"]; ok || len(outputs) != 1 || outputs["audit"] != 1 {
+		t.Fatalf("got %v, want only audit", outputs)
+	}
+}
+
+// ctxProcessor blocks until the context of its call is done
+type ctxProcessor struct {
+	anyProcessor
+	started chan struct{}
+}
+
+func (p *ctxProcessor) ProcessContext(ctx context.Context, msg any) (any, error) {
+	close(p.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestGraphDataFlowDestroyCancelsProcess(t *testing.T) {
+	started := make(chan struct{})
+	df := NewDataFlow("graph").(*GraphDataFlow)
+	df.Register(&ctxProcessor{started: started}, []DataFlowOption{NewDataFlowNameOption("wait")})
+	if err := df.Init(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := df.Process(1)
+		done <- err
+	}()
+	<-started
+	go df.Destroy()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Destroy didn't cancel Process")
+	}
+}
+
+func TestGraphDataFlowExport(t *testing.T) {
+	id := func(msg any) (any, error) { return msg, nil }
+	df := NewDataFlow("graph").(*GraphDataFlow)
+	df.Register(&funcProcessor{fn: id}, []DataFlowOption{NewDataFlowNameOption("decode")})
+	df.Register(&funcProcessor{fn: id}, []DataFlowOption{NewDataFlowNameOption("persist")})
+	df.AddEdge("valid", "decode", "persist", func(msg any) bool { return true })
+
+	dot := df.DOT()
+	if !strings.Contains(dot, `"decode" -> "persist" [label="valid", style=dashed];`) {
+		t.Fatalf("unexpected DOT:\n%s", dot)
+	}
+	mermaid := df.Mermaid()
+	if !strings.HasPrefix(mermaid, "flowchart LR\n") || !strings.Contains(mermaid, `n0 -.->|"valid"| n1`) {
+		t.Fatalf("unexpected Mermaid:\n%s", mermaid)
+	}
+}
@@ -0,0 +1,154 @@
+package mrun
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/odysseythink/mrun/version"
+)
+
+// MRUN_VERSION is the version of mrun, plugins require it by the MrunVersion
+// constraint of their manifest.
+const MRUN_VERSION = "0.1.0"
+
+// Manifest describes a libso module. A plugin can export one as a variable
+// named Manifest:
+//
+//	var Manifest = mrun.Manifest{
+//		Name:        "sensor",
+//		Version:     "1.2.0",
+//		MrunVersion: ">= 0.1, < 1.0",
+//		DependsOn:   []string{"db"},
+//	}
+//
+// It's checked when the plugin is loaded. Name is the default alias of the
+// module and DependsOn its default dependencies.
+type Manifest struct {
+	Name        string
+	Version     string
+	MrunVersion string
+	DependsOn   []string
+}
+
+func (mf *Manifest) check() error {
+	if mf.Version != "" {
+		_, err := version.NewVersion(mf.Version)
+		if err != nil {
+			return fmt.Errorf("invalid manifest version(%s):%v", mf.Version, err)
+		}
+	}
+	if mf.MrunVersion != "" {
+		constraints, err := version.NewConstraint(mf.MrunVersion)
+		if err != nil {
+			return fmt.Errorf("invalid manifest mrun version constraint(%s):%v", mf.MrunVersion, err)
+		}
+		if !constraints.Check(version.Must(version.NewVersion(MRUN_VERSION))) {
+			return fmt.Errorf("requires mrun version %s, but mrun version is %s", mf.MrunVersion, MRUN_VERSION)
+		}
+	}
+	for _, v := range mf.DependsOn {
+		if v == "" {
+			return errors.New("manifest has an empty dependency")
+		}
+	}
+	return nil
+}
+
+// manifestOptions returns the registration options declared by the manifest,
+// they go before the caller's options so that those can override them.
+func (m *LibsoModule) manifestOptions(options []ModuleMgrOption) []ModuleMgrOption {
+	if m.manifest == nil {
+		return options
+	}
+	opts := make([]ModuleMgrOption, 0, len(options)+2)
+	if m.manifest.Name != "" {
+		opts = append(opts, NewModuleAliasOption(m.manifest.Name))
+	}
+	if len(m.manifest.DependsOn) > 0 {
+		opts = append(opts, NewModuleDependsOnOption(m.manifest.DependsOn...))
+	}
+	return append(opts, options...)
+}
+
+// RegisterLibsoDir loads every .so file of dir and registers the compatible
+// ones, in the order of their manifest dependencies. A module without
+// manifest is registered under its file name without the .so suffix. The
+// plugins failing to load or to register are skipped and reported by the
+// returned error.
+func (mgr *ModuleMgr) RegisterLibsoDir(dir string, options []ModuleMgrOption, args ...any) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("[E]read plugin dir(%s) failed:%v\n", dir, err)
+		return fmt.Errorf("read plugin dir(%s) failed:%v", dir, err)
+	}
+
+	var errs []error
+	pending := make([]*LibsoModule, 0)
+	aliases := make(map[*LibsoModule]string)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".so") {
+			continue
+		}
+		m, err := loadLibso(filepath.Join(dir, e.Name()))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		aliases[m] = strings.TrimSuffix(e.Name(), ".so")
+		if m.manifest != nil && m.manifest.Name != "" {
+			aliases[m] = m.manifest.Name
+		}
+		pending = append(pending, m)
+	}
+
+	// register the modules whose dependencies are registered first, so that
+	// it also works on a running manager
+	for len(pending) > 0 {
+		rest := pending[:0]
+		for _, m := range pending {
+			if !mgr.libsoDepsReady(m, pending) {
+				rest = append(rest, m)
+				continue
+			}
+			opts := m.manifestOptions([]ModuleMgrOption{NewModuleAliasOption(aliases[m])})
+			err = mgr.Register(m, append(opts, options...), args...)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("register Module(%s) failed:%v", m.path, err))
+			}
+		}
+		if len(rest) == len(pending) {
+			// remaining ones have unmet or cyclic dependencies, let
+			// Register report them
+			for _, m := range rest {
+				opts := m.manifestOptions([]ModuleMgrOption{NewModuleAliasOption(aliases[m])})
+				err = mgr.Register(m, append(opts, options...), args...)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("register Module(%s) failed:%v", m.path, err))
+				}
+			}
+			break
+		}
+		pending = rest
+	}
+	return errors.Join(errs...)
+}
+
+// libsoDepsReady reports whether none of m's manifest dependencies is among
+// the pending plugins.
+func (mgr *ModuleMgr) libsoDepsReady(m *LibsoModule, pending []*LibsoModule) bool {
+	if m.manifest == nil {
+		return true
+	}
+	for _, dep := range m.manifest.DependsOn {
+		for _, v := range pending {
+			if v != m && v.manifest != nil && v.manifest.Name == dep {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -2,18 +2,127 @@ package mrun
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"plugin"
+	"strings"
 )
 
+// ErrLibsoAlreadyLoaded is returned when a plugin has the plugin path of a
+// plugin loaded before. The go linker derives the plugin path from the
+// package, so the versions of a module must be built with distinct
+// -ldflags=-pluginpath=<alias>@<version> to be loaded by the same process.
+var ErrLibsoAlreadyLoaded = errors.New("plugin path already loaded, build every version with its own -ldflags=-pluginpath")
+
+// openLibso loads a plugin module, tests replace it.
+var openLibso = loadLibso
+
 type LibsoModule struct {
 	init     func(args ...any) error
 	destroy  func()
 	runOnce  func(ctx context.Context) error
 	userData func() any
+	migrate  func(old any) error
+	manifest *Manifest
+	path     string
+	version  string
+	previous any
+}
+
+// loadLibso opens the plugin libname and looks up the module functions.
+func loadLibso(libname string) (*LibsoModule, error) {
+	m := &LibsoModule{path: libname}
+	plug, err := plugin.Open(libname)
+	if err != nil && strings.Contains(err.Error(), "plugin already loaded") {
+		log.Printf("[E]load Module(%s) failed:%v\n", libname, ErrLibsoAlreadyLoaded)
+		return nil, fmt.Errorf("load Module(%s) failed:%w", libname, ErrLibsoAlreadyLoaded)
+	}
+	if err != nil {
+		log.Printf("[E]load Module(%s) failed:%v\n", libname, err)
+		return nil, fmt.Errorf("load Module(%s) failed:%v", libname, err)
+	}
+	var symbol plugin.Symbol
+	var ok bool
+	// Manifest is optional, it's checked first to report incompatible
+	// plugins before looking up the module functions
+	symbol, err = plug.Lookup("Manifest")
+	if err == nil {
+		m.manifest, ok = symbol.(*Manifest)
+		if !ok {
+			log.Printf("[E]Module(%s) Manifest has wrong type(%T), want %T, the plugin may be built against another mrun\n", libname, symbol, m.manifest)
+			return nil, fmt.Errorf("Module(%s) Manifest has wrong type(%T), want %T, the plugin may be built against another mrun", libname, symbol, m.manifest)
+		}
+		err = m.manifest.check()
+		if err != nil {
+			log.Printf("[E]Module(%s) incompatible:%v\n", libname, err)
+			return nil, fmt.Errorf("Module(%s) incompatible:%v", libname, err)
+		}
+	}
+
+	symbol, err = plug.Lookup("Init")
+	if err != nil {
+		log.Printf("[E]Module(%s) Lookup Init function failed:%v\n", libname, err)
+		return nil, fmt.Errorf("Module(%s) Lookup Init function failed:%v", libname, err)
+	}
+	m.init, ok = symbol.(func(...any) error)
+	if !ok {
+		log.Printf("[E]Module(%s) Init function has wrong type(%T), want func(...any) error\n", libname, symbol)
+		return nil, fmt.Errorf("Module(%s) Init function has wrong type(%T), want func(...any) error", libname, symbol)
+	}
+
+	symbol, err = plug.Lookup("Destroy")
+	if err != nil {
+		log.Printf("[E]Module(%s) Lookup Destroy function failed:%v\n", libname, err)
+		return nil, fmt.Errorf("Module(%s) Lookup Destroy function failed:%v", libname, err)
+	}
+	m.destroy, ok = symbol.(func())
+	if !ok {
+		log.Printf("[E]Module(%s) Destroy function has wrong type(%T), want func()\n", libname, symbol)
+		return nil, fmt.Errorf("Module(%s) Destroy function has wrong type(%T), want func()", libname, symbol)
+	}
+
+	symbol, err = plug.Lookup("RunOnce")
+	if err != nil {
+		log.Printf("[E]Module(%s) Lookup RunOnce function failed:%v", libname, err)
+		return nil, fmt.Errorf("Module(%s) Lookup RunOnce function failed:%v", libname, err)
+	}
+	m.runOnce, ok = symbol.(func(ctx context.Context) error)
+	if !ok {
+		log.Printf("[E]Module(%s) RunOnce function has wrong type(%T), want func(context.Context) error\n", libname, symbol)
+		return nil, fmt.Errorf("Module(%s) RunOnce function has wrong type(%T), want func(context.Context) error", libname, symbol)
+	}
+
+	symbol, err = plug.Lookup("UserData")
+	if err != nil {
+		log.Printf("[E]Module(%s) Lookup UserData function failed:%v", libname, err)
+		return nil, fmt.Errorf("Module(%s) Lookup UserData function failed:%v", libname, err)
+	}
+	m.userData, ok = symbol.(func() any)
+	if !ok {
+		log.Printf("[E]Module(%s) UserData function has wrong type(%T), want func() any\n", libname, symbol)
+		return nil, fmt.Errorf("Module(%s) UserData function has wrong type(%T), want func() any", libname, symbol)
+	}
+	if m.init == nil || m.destroy == nil || m.runOnce == nil || m.userData == nil {
+		log.Printf("[E]Module(%s) both init, destroy, runOnce and userData must be provied\n", libname)
+		return nil, fmt.Errorf("Module(%s) both init, destroy, runOnce and userData must be provied", libname)
+	}
+
+	// Migrate is optional, it receives the UserData of the replaced version
+	symbol, err = plug.Lookup("Migrate")
+	if err == nil {
+		m.migrate, ok = symbol.(func(any) error)
+		if !ok {
+			log.Printf("[E]Module(%s) Migrate function has wrong type(%T), want func(any) error\n", libname, symbol)
+			return nil, fmt.Errorf("Module(%s) Migrate function has wrong type(%T), want func(any) error", libname, symbol)
+		}
+	}
+	return m, nil
 }
 
 func (m *LibsoModule) Init(args ...any) error {
 	if m.init != nil {
-		return m.init()
+		return m.init(args...)
 	}
 	return nil
 }
@@ -34,3 +143,28 @@ func (m *LibsoModule) UserData() any {
 	}
 	return nil
 }
+
+// Path returns the file the module was loaded from.
+func (m *LibsoModule) Path() string {
+	return m.path
+}
+
+// Version returns the version the module was loaded as by ReloadLibso, or
+// else the version of its manifest.
+func (m *LibsoModule) Version() string {
+	if m.version == "" && m.manifest != nil {
+		return m.manifest.Version
+	}
+	return m.version
+}
+
+// Manifest returns the manifest exported by the plugin, nil if it has none.
+func (m *LibsoModule) Manifest() *Manifest {
+	return m.manifest
+}
+
+// PreviousUserData returns the UserData of the version this module replaced
+// in ReloadLibso, nil if it didn't replace any.
+func (m *LibsoModule) PreviousUserData() any {
+	return m.previous
+}
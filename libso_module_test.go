@@ -0,0 +1,216 @@
+package mrun
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseLibsoName(t *testing.T) {
+	tests := []struct {
+		name  string
+		alias string
+		ver   string
+		ok    bool
+	}{
+		{"/plugins/sensor@1.2.0.so", "sensor", "1.2.0", true},
+		{"my-module@2.so", "my-module", "2.0.0", true},
+		{"sensor.so", "", "", false},
+		{"@1.0.0.so", "", "", false},
+		{"sensor@latest.so", "", "", false},
+		{"sensor@1.0.0.dll", "", "", false},
+	}
+	for _, tt := range tests {
+		alias, ver, ok := parseLibsoName(tt.name)
+		if ok != tt.ok || alias != tt.alias {
+			t.Errorf("parseLibsoName(%s)=%s,%v, want %s,%v", tt.name, alias, ok, tt.alias, tt.ok)
+			continue
+		}
+		if ok && ver.String() != tt.ver {
+			t.Errorf("parseLibsoName(%s) version=%s, want %s", tt.name, ver, tt.ver)
+		}
+	}
+}
+
+func TestManifestCheck(t *testing.T) {
+	tests := []struct {
+		manifest Manifest
+		ok       bool
+	}{
+		{Manifest{Name: "sensor"}, true},
+		{Manifest{Name: "sensor", Version: "1.2.0", MrunVersion: ">= 0.1, < 1.0", DependsOn: []string{"db"}}, true},
+		{Manifest{Name: "sensor", MrunVersion: "~> " + MRUN_VERSION}, true},
+		{Manifest{Name: "sensor", MrunVersion: ">= 99.0"}, false},
+		{Manifest{Name: "sensor", MrunVersion: "latest"}, false},
+		{Manifest{Name: "sensor", Version: "v-x"}, false},
+		{Manifest{Name: "sensor", DependsOn: []string{""}}, false},
+	}
+	for _, tt := range tests {
+		err := tt.manifest.check()
+		if (err == nil) != tt.ok {
+			t.Errorf("check(%+v)=%v, want ok=%v", tt.manifest, err, tt.ok)
+		}
+	}
+}
+
+// fakeLibso is a plugin module loaded by a fake openLibso
+type fakeLibso struct {
+	LibsoModule
+	runs      atomic.Int32
+	destroyed atomic.Bool
+}
+
+func newFakeLibso(path, ver string, state any) *fakeLibso {
+	m := &fakeLibso{}
+	m.path, m.version = path, ver
+	m.init = func(args ...any) error { return nil }
+	m.destroy = func() { m.destroyed.Store(true) }
+	m.runOnce = func(ctx context.Context) error {
+		m.runs.Add(1)
+		return nil
+	}
+	m.userData = func() any { return state }
+	return m
+}
+
+// withLibsos makes openLibso return the modules of libs by path
+func withLibsos(t *testing.T, libs map[string]*LibsoModule) *atomic.Int32 {
+	var opened atomic.Int32
+	open := openLibso
+	openLibso = func(libname string) (*LibsoModule, error) {
+		opened.Add(1)
+		m, ok := libs[libname]
+		if !ok {
+			return nil, errors.New("no such plugin")
+		}
+		return m, nil
+	}
+	t.Cleanup(func() { openLibso = open })
+	return &opened
+}
+
+func TestReloadLibsoRollback(t *testing.T) {
+	mgr := NewModuleMgr(t.Name())
+	old := newFakeLibso("sensor@1.0.0.so", "1.0.0", "state-1")
+	if err := mgr.Register(&old.LibsoModule, []ModuleMgrOption{NewModuleAliasOption("sensor")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Destroy()
+
+	badMigrate := newFakeLibso("sensor@1.1.0.so", "", nil)
+	var migrated any
+	badMigrate.migrate = func(prev any) error {
+		migrated = prev
+		return errors.New("incompatible state")
+	}
+	badInit := newFakeLibso("sensor@1.2.0.so", "", nil)
+	badInit.init = func(args ...any) error { return errors.New("init failed") }
+	good := newFakeLibso("sensor@1.3.0.so", "", nil)
+	withLibsos(t, map[string]*LibsoModule{
+		"sensor@1.1.0.so": &badMigrate.LibsoModule,
+		"sensor@1.2.0.so": &badInit.LibsoModule,
+		"sensor@1.3.0.so": &good.LibsoModule,
+	})
+
+	running := func(m *fakeLibso) bool {
+		n := m.runs.Load()
+		deadline := time.Now().Add(time.Second)
+		for m.runs.Load() == n && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		return m.runs.Load() != n
+	}
+	registered := func() IModule {
+		ms := mgr.GetModulesByAlias("sensor")
+		if len(ms) != 1 {
+			t.Fatalf("%d modules under sensor", len(ms))
+		}
+		return ms[0]
+	}
+
+	// a failed migrate restarts the old module
+	if err := mgr.ReloadLibso("sensor", "sensor@1.1.0.so", "1.1.0", nil); err == nil {
+		t.Fatal("failed migrate accepted")
+	}
+	if migrated != "state-1" || registered() != &old.LibsoModule || !running(old) {
+		t.Fatalf("old module not restored after failed migrate, migrated %v", migrated)
+	}
+	// a failed registration registers the old module again
+	if err := mgr.ReloadLibso("sensor", "sensor@1.2.0.so", "1.2.0", nil); err == nil {
+		t.Fatal("failed init accepted")
+	}
+	if registered() != &old.LibsoModule || !running(old) {
+		t.Fatal("old module not restored after failed registration")
+	}
+	if err := mgr.ReloadLibso("sensor", "sensor@1.0.0.so", "1.0.0", nil); err == nil {
+		t.Fatal("reload from the loaded file accepted")
+	}
+
+	if err := mgr.ReloadLibso("sensor", "sensor@1.3.0.so", "1.3.0", nil); err != nil {
+		t.Fatal(err)
+	}
+	if registered() != &good.LibsoModule || !running(good) || !old.destroyed.Load() {
+		t.Fatal("new version not running in place of the old one")
+	}
+	if good.PreviousUserData() != "state-1" || good.Version() != "1.3.0" {
+		t.Fatalf("got previous %v version %s", good.PreviousUserData(), good.Version())
+	}
+}
+
+func TestLibsoWatcher(t *testing.T) {
+	dir := t.TempDir()
+	touch := func(name string) {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mgr := NewModuleMgr(t.Name())
+	current := newFakeLibso(filepath.Join(dir, "sensor@1.0.0.so"), "1.0.0", nil)
+	mgr.Register(&current.LibsoModule, []ModuleMgrOption{NewModuleAliasOption("sensor")})
+	next := newFakeLibso("", "", nil)
+	opened := withLibsos(t, map[string]*LibsoModule{
+		filepath.Join(dir, "sensor@1.1.0.so"): &next.LibsoModule,
+	})
+	w := NewLibsoWatcher(mgr, dir, time.Hour, nil)
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the registered version isn't reloaded
+	touch("sensor@1.0.0.so")
+	touch("sensor@0.9.0.so")
+	if err := w.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := opened.Load(); n != 0 {
+		t.Fatalf("loaded %d plugins of the registered version", n)
+	}
+
+	// polled once per interval
+	touch("sensor@1.1.0.so")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w.RunOnce(ctx)
+	if n := opened.Load(); n != 0 {
+		t.Fatal("polled before the interval elapsed")
+	}
+	w.poll()
+	if ms := mgr.GetModulesByAlias("sensor"); len(ms) != 1 || ms[0] != &next.LibsoModule || next.Version() != "1.1.0" {
+		t.Fatalf("got %v, want version 1.1.0", ms)
+	}
+
+	// a failed version isn't retried
+	touch("sensor@1.2.0.so")
+	w.poll()
+	w.poll()
+	if n := opened.Load(); n != 2 {
+		t.Fatalf("loaded %d plugins, want 2", n)
+	}
+}
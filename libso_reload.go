@@ -0,0 +1,281 @@
+package mrun
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/odysseythink/mrun/version"
+)
+
+// configOption returns an option copying the registration settings of info,
+// it lets a replacing module inherit them.
+func (info *moduleInfo) configOption() ModuleMgrOption {
+	return func(mgr *ModuleMgr, dst *moduleInfo) {
+		dst.alias = info.alias
+		dst.dependsOn = append([]string(nil), info.dependsOn...)
+		dst.order = info.order
+		dst.period = info.period
+		dst.schedule = info.schedule
+		dst.cron = info.cron
+		dst.trigger = info.trigger
+		dst.panicPolicy = info.panicPolicy
+		dst.stopTimeout = info.stopTimeout
+		dst.onModuleError = info.onModuleError
+	}
+}
+
+// ReloadLibso loads libname as the given version of the module registered
+// under alias and hands over to it: the old module is stopped, its UserData
+// is passed to the optional Migrate function of the new plugin and kept
+// available by LibsoModule.PreviousUserData, then the old module is
+// unregistered and the new one registered under alias with the old module's
+// settings, overridden by its manifest and then by options. nil args reuse the old module's args. If
+// there's no module under alias the new one is simply registered.
+//
+// Go plugins can't be unloaded, so every version must be built to its own
+// file and stays in memory once loaded. The go runtime also refuses a plugin
+// whose plugin path is already loaded, which is the same for every build of
+// a package, so every version must be built with its own plugin path, e.g.
+//
+//	go build -buildmode=plugin -ldflags=-pluginpath=sensor@1.2.0 -o sensor@1.2.0.so
+//
+// else loading it fails with ErrLibsoAlreadyLoaded. Reloading from the file
+// the module was loaded from fails as well.
+func (mgr *ModuleMgr) ReloadLibso(alias, libname, ver string, options []ModuleMgrOption, args ...any) error {
+	if alias == "" {
+		log.Printf("[E]invalid arg\n")
+		return fmt.Errorf("invalid arg")
+	}
+	if !strings.HasSuffix(libname, ".so") {
+		log.Printf("[E]libname(%s) must be a so lib\n", libname)
+		return fmt.Errorf("libname(%s) must be a so lib", libname)
+	}
+	olds := mgr.GetModulesByAlias(alias)
+	if len(olds) > 1 {
+		log.Printf("[E]alias(%s) is shared by %d modules\n", alias, len(olds))
+		return fmt.Errorf("alias(%s) is shared by %d modules", alias, len(olds))
+	}
+	if len(olds) == 1 {
+		if lm, ok := olds[0].(*LibsoModule); ok && lm.path == libname {
+			log.Printf("[E]Module(%s) already loaded from %s\n", alias, libname)
+			return fmt.Errorf("Module(%s) already loaded from %s", alias, libname)
+		}
+	}
+	m, err := openLibso(libname)
+	if err != nil {
+		return err
+	}
+	if m.manifest != nil && m.manifest.Name != "" && m.manifest.Name != alias {
+		log.Printf("[E]Module(%s) manifest name(%s) doesn't match alias(%s)\n", libname, m.manifest.Name, alias)
+		return fmt.Errorf("Module(%s) manifest name(%s) doesn't match alias(%s)", libname, m.manifest.Name, alias)
+	}
+	m.version = ver
+
+	var old IModule
+	var oldInfo *moduleInfo
+	if len(olds) == 1 {
+		old = olds[0]
+		oldInfo = mgr.GetModuleInfo(old)
+	}
+	if oldInfo != nil {
+		mgr.stopModule(oldInfo)
+		m.previous = old.UserData()
+		if args == nil {
+			args = oldInfo.args
+		}
+	}
+	if m.migrate != nil {
+		err = m.migrate(m.previous)
+		if err != nil {
+			log.Printf("[E]Module(%s) migrate from previous version failed:%v\n", libname, err)
+			if ctx, _ := mgr.context(); oldInfo != nil && ctx != nil {
+				mgr.runModule(oldInfo)
+			}
+			return fmt.Errorf("Module(%s) migrate from previous version failed:%v", libname, err)
+		}
+	}
+
+	opts := make([]ModuleMgrOption, 0, len(options)+2)
+	if oldInfo != nil {
+		opts = append(opts, oldInfo.configOption())
+		mgr.UnRegister(old)
+	}
+	opts = append(opts, m.manifestOptions(options)...)
+	opts = append(opts, NewModuleAliasOption(alias))
+	err = mgr.Register(m, opts, args...)
+	if err != nil {
+		log.Printf("[E]register Module(%s) version(%s) failed:%v\n", libname, ver, err)
+		if oldInfo != nil {
+			rerr := mgr.Register(old, []ModuleMgrOption{oldInfo.configOption()}, oldInfo.args...)
+			if rerr != nil {
+				log.Printf("[E]restore previous Module(%s) failed:%v\n", alias, rerr)
+			}
+		}
+		return fmt.Errorf("register Module(%s) version(%s) failed:%v", libname, ver, err)
+	}
+	log.Printf("[D]Module(%s) reloaded from %s version(%s)\n", alias, libname, ver)
+	return nil
+}
+
+// parseLibsoName splits a plugin file name of the form <alias>@<version>.so.
+func parseLibsoName(name string) (alias string, ver *version.Version, ok bool) {
+	base, found := strings.CutSuffix(filepath.Base(name), ".so")
+	if !found {
+		return "", nil, false
+	}
+	alias, verStr, found := strings.Cut(base, "@")
+	if !found || alias == "" {
+		return "", nil, false
+	}
+	v, err := version.NewVersion(verStr)
+	if err != nil {
+		return "", nil, false
+	}
+	return alias, v, true
+}
+
+const DEFAULT_LIBSO_WATCH_INTERVAL = time.Second
+
+// LibsoWatcher is a module polling a directory for plugin builds named
+// <alias>@<version>.so, e.g. sensor@1.2.0.so. Whenever a version newer than
+// the loaded one shows up, the module registered under alias is replaced by
+// ReloadLibso. The loaded version is the Version of the LibsoModule
+// registered under alias, until the watcher reloaded it.
+type LibsoWatcher struct {
+	mgr      *ModuleMgr
+	dir      string
+	interval time.Duration
+	options  []ModuleMgrOption
+	args     []any
+	lastPoll time.Time
+	versions map[string]*version.Version
+	failed   map[string]bool
+}
+
+// NewLibsoWatcher returns a watcher polling dir every interval,
+// DEFAULT_LIBSO_WATCH_INTERVAL if it's 0, and reloading modules of mgr.
+// options and args are used to register the loaded modules.
+func NewLibsoWatcher(mgr *ModuleMgr, dir string, interval time.Duration, options []ModuleMgrOption, args ...any) *LibsoWatcher {
+	if mgr == nil || dir == "" || interval < 0 {
+		log.Printf("[E]invalid arg\n")
+		return nil
+	}
+	if interval == 0 {
+		interval = DEFAULT_LIBSO_WATCH_INTERVAL
+	}
+	return &LibsoWatcher{
+		mgr:      mgr,
+		dir:      dir,
+		interval: interval,
+		options:  options,
+		args:     args,
+		versions: make(map[string]*version.Version),
+		failed:   make(map[string]bool),
+	}
+}
+
+func (w *LibsoWatcher) Init(args ...any) error {
+	st, err := os.Stat(w.dir)
+	if err != nil {
+		log.Printf("[E]stat plugin dir(%s) failed:%v\n", w.dir, err)
+		return fmt.Errorf("stat plugin dir(%s) failed:%v", w.dir, err)
+	}
+	if !st.IsDir() {
+		log.Printf("[E]plugin dir(%s) is not a directory\n", w.dir)
+		return fmt.Errorf("plugin dir(%s) is not a directory", w.dir)
+	}
+	return nil
+}
+
+func (w *LibsoWatcher) Destroy() {
+}
+
+func (w *LibsoWatcher) RunOnce(ctx context.Context) error {
+	if wait := w.interval - time.Since(w.lastPoll); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+	}
+	w.lastPoll = time.Now()
+	w.poll()
+	return nil
+}
+
+// poll reloads the modules a newer version of which is in the directory.
+func (w *LibsoWatcher) poll() {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		log.Printf("[E]read plugin dir(%s) failed:%v\n", w.dir, err)
+		return
+	}
+
+	// only the newest version of each alias is loaded
+	newest := make(map[string]*version.Version)
+	paths := make(map[string]string)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		path := filepath.Join(w.dir, e.Name())
+		if w.failed[path] {
+			continue
+		}
+		alias, v, ok := parseLibsoName(e.Name())
+		if !ok {
+			continue
+		}
+		if cur, ok := newest[alias]; ok && !v.GreaterThan(cur) {
+			continue
+		}
+		newest[alias] = v
+		paths[alias] = path
+	}
+
+	for alias, v := range newest {
+		if cur := w.loaded(alias); cur != nil && !v.GreaterThan(cur) {
+			continue
+		}
+		err = w.mgr.ReloadLibso(alias, paths[alias], v.String(), w.options, w.args...)
+		if err != nil {
+			w.failed[paths[alias]] = true
+			continue
+		}
+		w.versions[alias] = v
+	}
+}
+
+// loaded returns the version of the module registered under alias, nil if
+// it's unknown.
+func (w *LibsoWatcher) loaded(alias string) *version.Version {
+	if v, ok := w.versions[alias]; ok {
+		return v
+	}
+	ms := w.mgr.GetModulesByAlias(alias)
+	if len(ms) != 1 {
+		return nil
+	}
+	m, ok := ms[0].(*LibsoModule)
+	if !ok || m.Version() == "" {
+		return nil
+	}
+	v, err := version.NewVersion(m.Version())
+	if err != nil {
+		log.Printf("[E]Module(%s) version(%s) invalid:%v\n", alias, m.Version(), err)
+		return nil
+	}
+	w.versions[alias] = v
+	return v
+}
+
+func (w *LibsoWatcher) UserData() any {
+	return nil
+}
@@ -14,3 +14,19 @@ type IModule interface {
 	RunOnce(ctx context.Context) error
 	UserData() any
 }
+
+// Drainer is an optional interface a module can implement to finish its
+// in-flight work before it's stopped. Drain is called by ModuleMgr.Shutdown
+// while the module is still running, ctx carries the module's stop deadline.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// HealthChecker is an optional interface a module can implement to report its
+// health to the liveness and readiness probes of ModuleMgr. A nil error means
+// alive, respectively ready. The modules are checked concurrently, ctx is
+// canceled once the check is given up.
+type HealthChecker interface {
+	CheckLiveness(ctx context.Context) error
+	CheckReadiness(ctx context.Context) error
+}
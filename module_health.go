@@ -0,0 +1,192 @@
+package mrun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/odysseythink/mrun/metrics"
+)
+
+const (
+	DEFAULT_HEALTH_CHECK_TIMEOUT = 5 * time.Second
+)
+
+// ModuleHealth is the probe result of one module.
+type ModuleHealth struct {
+	Alias   string `json:"alias,omitempty"`
+	Module  string `json:"module"`
+	State   string `json:"state"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HealthReport is the probe result of a module manager, it's healthy if all
+// its modules are.
+type HealthReport struct {
+	Healthy bool           `json:"healthy"`
+	Modules []ModuleHealth `json:"modules"`
+}
+
+// Err returns an error summarizing the unhealthy modules, nil if healthy.
+func (r *HealthReport) Err() error {
+	var errs []error
+	for _, v := range r.Modules {
+		if !v.Healthy {
+			errs = append(errs, errors.New(v.Module+"("+v.Alias+"):"+v.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Liveness probes whether the modules are alive: a failed module is dead,
+// otherwise the module's CheckLiveness decides if it implements
+// HealthChecker.
+func (mgr *ModuleMgr) Liveness(ctx context.Context) HealthReport {
+	return mgr.probe(ctx, func(ctx context.Context, status ModuleStatus, hc HealthChecker) error {
+		if status.State == ModuleFailed {
+			return errors.New("module failed")
+		}
+		if hc != nil {
+			return hc.CheckLiveness(ctx)
+		}
+		return nil
+	})
+}
+
+// Readiness probes whether the modules are ready: a module must be running,
+// and its CheckReadiness must succeed if it implements HealthChecker. A
+// blocking or trigger module which finished on its own is ready, it did its
+// job, see ModuleStatus.Finished.
+func (mgr *ModuleMgr) Readiness(ctx context.Context) HealthReport {
+	return mgr.probe(ctx, func(ctx context.Context, status ModuleStatus, hc HealthChecker) error {
+		if status.Finished {
+			return nil
+		}
+		if status.State != ModuleRunning {
+			return errors.New("module " + status.State.String())
+		}
+		if hc != nil {
+			return hc.CheckReadiness(ctx)
+		}
+		return nil
+	})
+}
+
+// probe checks the modules concurrently, each within the deadline of ctx or
+// DEFAULT_HEALTH_CHECK_TIMEOUT if it has none, so a slow module doesn't
+// use up the time of the others.
+func (mgr *ModuleMgr) probe(ctx context.Context, check func(context.Context, ModuleStatus, HealthChecker) error) HealthReport {
+	statuses := mgr.Status()
+	report := HealthReport{Healthy: true, Modules: make([]ModuleHealth, len(statuses))}
+	var wg sync.WaitGroup
+	for i, status := range statuses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Modules[i] = checkModule(ctx, status, check)
+		}()
+	}
+	wg.Wait()
+	for _, h := range report.Modules {
+		if !h.Healthy {
+			report.Healthy = false
+		}
+	}
+	return report
+}
+
+func checkModule(ctx context.Context, status ModuleStatus, check func(context.Context, ModuleStatus, HealthChecker) error) ModuleHealth {
+	var cancel context.CancelFunc
+	if _, ok := ctx.Deadline(); ok {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, DEFAULT_HEALTH_CHECK_TIMEOUT)
+	}
+	// tells a check still running that it's given up
+	defer cancel()
+
+	hc, _ := status.Module.(HealthChecker)
+	if a, ok := status.Module.(typedAdapter); ok {
+		hc, _ = a.typedModule().(HealthChecker)
+	}
+	h := ModuleHealth{
+		Alias:   status.Alias,
+		Module:  moduleTypeName(status.Module),
+		State:   status.State.String(),
+		Healthy: true,
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- check(ctx, status, hc)
+	}()
+	var err error
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		h.Healthy = false
+		h.Error = err.Error()
+	}
+	return h
+}
+
+// LivenessHandler serves the liveness probe, with status 200 when alive and
+// 503 otherwise. The body is the JSON encoded HealthReport.
+func (mgr *ModuleMgr) LivenessHandler() http.Handler {
+	return healthHandler(mgr.Liveness)
+}
+
+// ReadinessHandler serves the readiness probe like LivenessHandler.
+func (mgr *ModuleMgr) ReadinessHandler() http.Handler {
+	return healthHandler(mgr.Readiness)
+}
+
+// HealthHandler serves the liveness probe at /healthz and the readiness probe
+// at /readyz.
+func (mgr *ModuleMgr) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", mgr.LivenessHandler())
+	mux.Handle("/readyz", mgr.ReadinessHandler())
+	return mux
+}
+
+func healthHandler(probe func(context.Context) HealthReport) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := probe(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		if report.Healthy {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// Healthcheck returns a metrics.Healthcheck reflecting the readiness of the
+// manager, so that it's run by metrics.Registry.RunHealthchecks.
+func (mgr *ModuleMgr) Healthcheck() metrics.Healthcheck {
+	return metrics.NewHealthcheck(func(h metrics.Healthcheck) {
+		report := mgr.Readiness(context.Background())
+		if report.Healthy {
+			h.Healthy()
+		} else {
+			h.Unhealthy(report.Err())
+		}
+	})
+}
+
+func moduleTypeName(m IModule) string {
+	if a, ok := m.(typedAdapter); ok {
+		return fmt.Sprintf("%T", a.typedModule())
+	}
+	return fmt.Sprintf("%T", m)
+}
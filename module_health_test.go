@@ -0,0 +1,174 @@
+package mrun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type healthModule struct {
+	flakyModule
+	ready atomic.Bool
+}
+
+func (m *healthModule) CheckLiveness(ctx context.Context) error {
+	return nil
+}
+
+func (m *healthModule) CheckReadiness(ctx context.Context) error {
+	if !m.ready.Load() {
+		return errors.New("warming up")
+	}
+	return nil
+}
+
+func TestModuleMgrHealth(t *testing.T) {
+	mgr := NewModuleMgr("test")
+	hm := &healthModule{}
+	if err := mgr.Register(hm, []ModuleMgrOption{NewModuleAliasOption("cache")}); err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	if err := mgr.Register(&flakyModule{}, nil); err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+
+	srv := httptest.NewServer(mgr.HealthHandler())
+	defer srv.Close()
+	get := func(path string) (int, HealthReport) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("get %s failed:%v", path, err)
+		}
+		defer resp.Body.Close()
+		var report HealthReport
+		if err = json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			t.Fatalf("decode %s failed:%v", path, err)
+		}
+		return resp.StatusCode, report
+	}
+
+	// registered modules are alive but not ready
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Fatalf("/healthz=%d before init", code)
+	}
+	if code, _ := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("/readyz=%d before init", code)
+	}
+
+	if err := mgr.Init(); err != nil {
+		t.Fatalf("init failed:%v", err)
+	}
+	defer mgr.Destroy()
+	code, report := get("/readyz")
+	if code != http.StatusServiceUnavailable || len(report.Modules) != 2 {
+		t.Fatalf("/readyz=%d %+v while warming up", code, report)
+	}
+	for _, v := range report.Modules {
+		if v.Alias == "cache" && (v.Healthy || v.Error != "warming up") {
+			t.Fatalf("unexpected cache health:%+v", v)
+		}
+		if v.Alias != "cache" && !v.Healthy {
+			t.Fatalf("module without checker should be healthy while running:%+v", v)
+		}
+	}
+
+	hm.ready.Store(true)
+	if code, report = get("/readyz"); code != http.StatusOK || !report.Healthy {
+		t.Fatalf("/readyz=%d %+v once ready", code, report)
+	}
+	h := mgr.Healthcheck()
+	h.Check()
+	if h.Error() != nil {
+		t.Fatalf("healthcheck failed:%v", h.Error())
+	}
+}
+
+// slowHealthModule hangs its checks until they're given up.
+type slowHealthModule struct {
+	flakyModule
+	canceled chan struct{}
+}
+
+func (m *slowHealthModule) CheckLiveness(ctx context.Context) error {
+	<-ctx.Done()
+	close(m.canceled)
+	return ctx.Err()
+}
+
+func (m *slowHealthModule) CheckReadiness(ctx context.Context) error {
+	return nil
+}
+
+func TestModuleMgrHealthSlowModule(t *testing.T) {
+	mgr := NewModuleMgr("test")
+	slow := &slowHealthModule{canceled: make(chan struct{})}
+	if err := mgr.Register(slow, []ModuleMgrOption{NewModuleAliasOption("slow")}); err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	if err := mgr.Register(&healthModule{}, []ModuleMgrOption{NewModuleAliasOption("fast")}); err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	report := mgr.Liveness(ctx)
+	if report.Healthy {
+		t.Fatal("slow module reported healthy")
+	}
+	for _, h := range report.Modules {
+		if h.Alias == "fast" && !h.Healthy {
+			t.Fatalf("fast module unhealthy behind the slow one:%s", h.Error)
+		}
+		if h.Alias == "slow" && h.Healthy {
+			t.Fatal("slow module healthy")
+		}
+	}
+	select {
+	case <-slow.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("slow check not canceled")
+	}
+}
+
+func TestModuleMgrReadinessFinished(t *testing.T) {
+	mgr := NewModuleMgr("test")
+	trigger := make(chan struct{})
+	err := mgr.Register(&flakyModule{}, []ModuleMgrOption{NewModuleRunBlockingOption()})
+	if err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	err = mgr.Register(&flakyModule{}, []ModuleMgrOption{NewModuleRunTriggerOption(trigger)})
+	if err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	if err = mgr.Init(); err != nil {
+		t.Fatalf("init failed:%v", err)
+	}
+	defer mgr.Destroy()
+	close(trigger)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		finished := 0
+		for _, s := range mgr.Status() {
+			if s.Finished {
+				finished++
+			}
+		}
+		if finished == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("modules should finish, got %+v", mgr.Status())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if report := mgr.Readiness(context.Background()); !report.Healthy {
+		t.Fatalf("finished modules should be ready, got %+v", report)
+	}
+}
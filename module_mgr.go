@@ -51,36 +51,86 @@ func NewModuleRunPeriodOption(msec uint) func(mgr *ModuleMgr, info *moduleInfo)
 		if msec == 0 {
 			msec = 1
 		}
+		info.schedule = scheduleFixedDelay
 		info.period = msec
 	}
 }
 
-func NewModuleMgr(name string) *ModuleMgr {
+// NewModuleDependsOnOption declares that the module depends on every module
+// registered under one of the given aliases. Dependencies are initialized and
+// started before the module, and destroyed after it. Before Init they may be
+// registered in any order, a dependency still missing makes Init fail.
+func NewModuleDependsOnOption(aliases ...string) func(mgr *ModuleMgr, info *moduleInfo) {
+	return func(mgr *ModuleMgr, info *moduleInfo) {
+		for _, alias := range aliases {
+			if alias == "" {
+				log.Printf("[E]invalid arg\n")
+				continue
+			}
+			info.dependsOn = append(info.dependsOn, alias)
+		}
+	}
+}
+
+func NewModuleMgr(name string, options ...SupervisorOption) *ModuleMgr {
 	if name == "" {
 		log.Printf("[E]invalid arg\n")
 		return nil
 	}
-	return &ModuleMgr{name: name}
+	// the list is created up front, the lazy creation in the readers
+	// races with concurrent calls
+	mgr := &ModuleMgr{name: name, modules: list.New()}
+	mgr.Supervise(options...)
+	return mgr
 }
 
 type moduleInfo struct {
 	m             IModule
 	args          []any
-	exitCh        chan struct{}
 	onModuleError func(IModule, error)
 	alias         string
+	dependsOn     []string
 	order         uint
 	period        uint
+	schedule      scheduleMode
+	cron          *cronSchedule
+	trigger       <-chan struct{}
+	panicPolicy   PanicPolicy
+	stopTimeout   time.Duration
+	optionErr     error
+
+	runMux          sync.Mutex
+	runID           uint64
+	cancel          context.CancelFunc
+	done            chan struct{}
+	destroyed       bool
+	state           ModuleState
+	finished        bool
+	lastErr         error
+	restarts        int
+	runCount        uint64
+	lastRunDuration time.Duration
+	startedAt       time.Time
 }
 
 type ModuleMgr struct {
-	name          string
-	modulesMux    sync.RWMutex
-	modules       *list.List
+	name       string
+	modulesMux sync.RWMutex
+	modules    *list.List
+	// ctxMux guards ctx and ctxCancelFunc, see context and start
+	ctxMux        sync.RWMutex
 	ctx           context.Context
 	wg            sync.WaitGroup
 	ctxCancelFunc context.CancelFunc
 	initOnce      sync.Once
+	supervisor    supervisor
+	restartMux    sync.Mutex
+
+	shutdownOnce   sync.Once
+	shutdownCtx    context.Context
+	shutdownCancel context.CancelFunc
+
+	pool *fleets.Pool
 }
 
 func (mgr *ModuleMgr) Contains(m IModule) bool {
@@ -169,6 +219,10 @@ func (mgr *ModuleMgr) addModule(info *moduleInfo) {
 	mgr.modulesMux.Unlock()
 }
 
+// Register adds m to the manager, m is initialized and started right away if
+// the manager is running. A dependency cycle is reported here, a missing
+// dependency only at Init unless the manager is running already, see
+// NewModuleDependsOnOption.
 func (mgr *ModuleMgr) Register(m IModule, options []ModuleMgrOption, args ...any) error {
 	if m == nil {
 		log.Printf("[E]invalid arg\n")
@@ -179,16 +233,8 @@ func (mgr *ModuleMgr) Register(m IModule, options []ModuleMgrOption, args ...any
 		log.Printf("[E]already register")
 		return fmt.Errorf("already register")
 	}
-	if mgr.ctx != nil {
-		err := m.Init(args...)
-		if err != nil {
-			log.Printf("[E]module init failed:%v\n", err)
-			return fmt.Errorf("module init failed:%v", err)
-		}
-	}
 	info := &moduleInfo{
 		m:      m,
-		exitCh: make(chan struct{}),
 		order:  9999,
 		period: 1,
 	}
@@ -200,76 +246,149 @@ func (mgr *ModuleMgr) Register(m IModule, options []ModuleMgrOption, args ...any
 	for _, v := range options {
 		v(mgr, info)
 	}
+	if info.optionErr != nil {
+		log.Printf("[E]invalid module option:%v\n", info.optionErr)
+		return info.optionErr
+	}
+	err := mgr.checkDependencies(info)
+	if err != nil {
+		log.Printf("[E]check module dependencies failed:%v\n", err)
+		return err
+	}
+	running, _ := mgr.context()
+	if running != nil {
+		err := mgr.initModule(info)
+		if err != nil {
+			return fmt.Errorf("module init failed:%v", err)
+		}
+	}
 	mgr.addModule(info)
-	if mgr.ctx != nil {
+	if running != nil {
 		mgr.runModule(info)
 	}
 	return nil
 }
 
-func (mgr *ModuleMgr) RegisterLibso(libname string, options []ModuleMgrOption, args ...any) error {
-	if !strings.HasSuffix(libname, ".so") {
-		log.Printf("[E]libname(%s) must be a so lib\n", libname)
-		return fmt.Errorf("libname(%s) must be a so lib", libname)
-	}
-	log.Printf("[D]ModuleName: %s\n", libname)
-	// return mSkeleton.Register(m, options, args...)
-	m := new(LibsoModule)
-	plug, err := plugin.Open(libname)
-	if err != nil {
-		log.Printf("[E]load Module(%s) failed:%v\n", libname, err)
-		return fmt.Errorf("load Module(%s) failed:%v", libname, err)
+// checkDependencies verifies that adding info to the registered modules
+// doesn't introduce a dependency cycle. Once the manager is running every
+// dependency must also be registered already, before that missing ones are
+// left to Init.
+func (mgr *ModuleMgr) checkDependencies(info *moduleInfo) error {
+	mgr.modulesMux.RLock()
+	if mgr.modules == nil {
+		mgr.modules = list.New()
 	}
-	var symbol plugin.Symbol
-	var ok bool
-	symbol, err = plug.Lookup("Init")
-	if err != nil {
-		log.Printf("[E]Module(%s) Lookup Init function failed:%v\n", libname, err)
-		return fmt.Errorf("Module(%s) Lookup Init function failed:%v", libname, err)
+	infos := make([]*moduleInfo, 0, mgr.modules.Len()+1)
+	e := mgr.modules.Front()
+	for e != nil {
+		infos = append(infos, e.Value.(*moduleInfo))
+		e = e.Next()
 	}
-	m.init, ok = symbol.(func(...any) error)
-	if !ok {
-		log.Printf("[E]Module(%s) Init function has wrong type\n", libname)
-		return fmt.Errorf("Module(%s) Init function has wrong type", libname)
+	mgr.modulesMux.RUnlock()
+	infos = append(infos, info)
+
+	ctx, _ := mgr.context()
+	_, err := sortModules(infos, ctx == nil)
+	return err
+}
+
+// name is the module's alias, or its type if it has none.
+func (info *moduleInfo) name() string {
+	if info.alias != "" {
+		return info.alias
 	}
+	return moduleTypeName(info.m)
+}
 
-	symbol, err = plug.Lookup("Destroy")
-	if err != nil {
-		log.Printf("[E]Module(%s) Lookup Destroy function failed:%v\n", libname, err)
-		return fmt.Errorf("Module(%s) Lookup Destroy function failed:%v", libname, err)
+// sortedModules returns the registered modules in start order: every module
+// comes after the modules it depends on, ties are broken by priority.
+func (mgr *ModuleMgr) sortedModules() ([]*moduleInfo, error) {
+	mgr.modulesMux.RLock()
+	if mgr.modules == nil {
+		mgr.modules = list.New()
 	}
-	m.destroy, ok = symbol.(func())
-	if !ok {
-		log.Printf("[E]Module(%s) Destroy function has wrong type\n", libname)
-		return fmt.Errorf("Module(%s) Destroy function has wrong type", libname)
+	infos := make([]*moduleInfo, 0, mgr.modules.Len())
+	e := mgr.modules.Front()
+	for e != nil {
+		infos = append(infos, e.Value.(*moduleInfo))
+		e = e.Next()
 	}
+	mgr.modulesMux.RUnlock()
 
-	symbol, err = plug.Lookup("RunOnce")
-	if err != nil {
-		log.Printf("[E]Module(%s) Lookup RunOnce function failed:%v", libname, err)
-		return fmt.Errorf("Module(%s) Lookup RunOnce function failed:%v", libname, err)
+	return sortModules(infos, false)
+}
+
+// sortModules topologically sorts infos, which must be ordered by priority.
+// A dependency on an alias no module is registered under is an error unless
+// allowMissing is set, in which case it is ignored.
+func sortModules(infos []*moduleInfo, allowMissing bool) ([]*moduleInfo, error) {
+	byAlias := make(map[string][]*moduleInfo)
+	for _, info := range infos {
+		if info.alias != "" {
+			byAlias[info.alias] = append(byAlias[info.alias], info)
+		}
 	}
-	m.runOnce, ok = symbol.(func(ctx context.Context) error)
-	if !ok {
-		log.Printf("[E]Module(%s) RunOnce function has wrong type", libname)
-		return fmt.Errorf("Module(%s) RunOnce function has wrong type", libname)
+
+	pending := make(map[*moduleInfo]int, len(infos))
+	dependents := make(map[*moduleInfo][]*moduleInfo)
+	for _, info := range infos {
+		for _, alias := range info.dependsOn {
+			deps, ok := byAlias[alias]
+			if !ok {
+				if allowMissing {
+					continue
+				}
+				return nil, fmt.Errorf("module(%s) depends on missing module(%s)", info.name(), alias)
+			}
+			for _, dep := range deps {
+				if dep == info {
+					return nil, fmt.Errorf("module(%s) depends on itself", info.alias)
+				}
+				pending[info]++
+				dependents[dep] = append(dependents[dep], info)
+			}
+		}
 	}
 
-	symbol, err = plug.Lookup("UserData")
-	if err != nil {
-		log.Printf("[E]Module(%s) Lookup UserData function failed:%v", libname, err)
-		return fmt.Errorf("Module(%s) Lookup UserData function failed:%v", libname, err)
+	sorted := make([]*moduleInfo, 0, len(infos))
+	done := make(map[*moduleInfo]bool, len(infos))
+	for len(sorted) < len(infos) {
+		var next *moduleInfo
+		for _, info := range infos {
+			if !done[info] && pending[info] == 0 {
+				next = info
+				break
+			}
+		}
+		if next == nil {
+			cycle := make([]string, 0)
+			for _, info := range infos {
+				if !done[info] {
+					cycle = append(cycle, info.name())
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between modules(%s)", strings.Join(cycle, ","))
+		}
+		done[next] = true
+		sorted = append(sorted, next)
+		for _, v := range dependents[next] {
+			pending[v]--
+		}
 	}
-	m.userData, ok = symbol.(func() any)
-	if !ok {
-		log.Printf("[E]Module(%s) UserData function has wrong type", libname)
-		return fmt.Errorf("Module(%s) UserData function has wrong type", libname)
+	return sorted, nil
+}
+
+func (mgr *ModuleMgr) RegisterLibso(libname string, options []ModuleMgrOption, args ...any) error {
+	if !strings.HasSuffix(libname, ".so") {
+		log.Printf("[E]libname(%s) must be a so lib\n", libname)
+		return fmt.Errorf("libname(%s) must be a so lib", libname)
 	}
-	if m.init == nil || m.destroy == nil || m.runOnce == nil || m.userData == nil {
-		log.Printf("[E]Module(%s) both init, destroy, runOnce and userData must be provied\n", libname)
-		return fmt.Errorf("Module(%s) both init, destroy, runOnce and userData must be provied", libname)
+	log.Printf("[D]ModuleName: %s\n", libname)
+	m, err := loadLibso(libname)
+	if err != nil {
+		return err
 	}
-	return mgr.Register(m, options, args...)
+	return mgr.Register(m, m.manifestOptions(options), args...)
 }
 
 func (mgr *ModuleMgr) RegisterLibsoWithModule(libname, modulename string, options []ModuleMgrOption, args ...any) error {
@@ -310,54 +429,42 @@ func (mgr *ModuleMgr) UnRegister(m IModule) error {
 		log.Printf("[E]module not register")
 		return fmt.Errorf("module not register")
 	}
-	// mgr.wg.Add(1)
-	// go func() {
-
-	if mgr.ctx != nil {
-		select {
-		case info.exitCh <- struct{}{}:
-			break
-		default:
-			// log.Println("notice exitCh failed")
-			break
-		}
+	info.runMux.Lock()
+	if info.cancel != nil {
+		info.cancel()
+	}
+	destroyed := info.destroyed
+	info.destroyed = true
+	info.runMux.Unlock()
+	if !destroyed {
+		info.setState(ModuleStopping, nil)
+		info.m.Destroy()
 	}
-	info.m.Destroy()
+	info.setState(ModuleStopped, nil)
 	mgr.DeleteModuleInfo(m)
-	// 	mgr.wg.Done()
-	// }()
 	return nil
 }
 
 func (mgr *ModuleMgr) Init() error {
 	var err error
 	mgr.initOnce.Do(func() {
-		mgr.modulesMux.RLock()
-		if mgr.modules == nil {
-			mgr.modules = list.New()
+		var infos []*moduleInfo
+		infos, err = mgr.sortedModules()
+		if err != nil {
+			log.Printf("[E]sort modules failed:%v\n", err)
+			return
 		}
-		m := mgr.modules.Front()
-		for m != nil {
-			err = m.Value.(*moduleInfo).m.Init(m.Value.(*moduleInfo).args...)
+		for _, info := range infos {
+			err = mgr.initModule(info)
 			if err != nil {
-				log.Printf("[E]module init failed:%v\n", err)
-				mgr.modulesMux.RUnlock()
 				return
 			}
-			m = m.Next()
 		}
-		mgr.modulesMux.RUnlock()
 
-		if mgr.ctx == nil {
-			mgr.ctx, mgr.ctxCancelFunc = context.WithCancel(context.Background())
-		}
-		mgr.modulesMux.RLock()
-		m = mgr.modules.Front()
-		for m != nil {
-			mgr.runModule(m.Value.(*moduleInfo))
-			m = m.Next()
+		mgr.start(context.Background())
+		for _, info := range infos {
+			mgr.runModule(info)
 		}
-		mgr.modulesMux.RUnlock()
 	})
 
 	return err
@@ -371,22 +478,40 @@ func (mgr *ModuleMgr) ModuleNum() int {
 }
 
 func (mgr *ModuleMgr) Destroy() {
-	if mgr.ctxCancelFunc != nil {
-		mgr.ctxCancelFunc()
+	if _, cancel := mgr.context(); cancel != nil {
+		cancel()
 
+		// wait for a pending restart to notice the cancellation
+		mgr.restartMux.Lock()
 		mgr.modulesMux.Lock()
 		if mgr.modules == nil {
 			mgr.modules = list.New()
 		}
+		infos := make([]*moduleInfo, 0, mgr.modules.Len())
 		e := mgr.modules.Front()
 		for e != nil {
-			mgr.destroy(e.Value.(*moduleInfo))
-			tmp := e.Next()
-			mgr.modules.Remove(e)
-			e = tmp
-
+			infos = append(infos, e.Value.(*moduleInfo))
+			e = e.Next()
+		}
+		sorted, err := sortModules(infos, true)
+		if err != nil {
+			log.Printf("[E]sort modules failed:%v, destroy by priority\n", err)
+			sorted = infos
+		}
+		for i := len(sorted) - 1; i >= 0; i-- {
+			sorted[i].runMux.Lock()
+			destroyed := sorted[i].destroyed
+			sorted[i].destroyed = true
+			sorted[i].runMux.Unlock()
+			if !destroyed {
+				sorted[i].setState(ModuleStopping, nil)
+				mgr.destroy(sorted[i])
+			}
+			sorted[i].setState(ModuleStopped, nil)
 		}
+		mgr.modules.Init()
 		mgr.modulesMux.Unlock()
+		mgr.restartMux.Unlock()
 	}
 	mgr.wg.Wait()
 }
@@ -451,34 +576,86 @@ func (mgr *ModuleMgr) runModule(info *moduleInfo) {
 		return
 	}
 
+	parent, _ := mgr.context()
+	ctx, cancel := context.WithCancel(parent)
+	done := make(chan struct{})
+	info.runMux.Lock()
+	info.runID++
+	runID := info.runID
+	info.cancel = cancel
+	info.done = done
+	info.destroyed = false
+	info.state = ModuleRunning
+	info.startedAt = time.Now()
+	info.runMux.Unlock()
+
 	mgr.wg.Add(1)
 	go func() {
-		var err error
-		timer := time.NewTimer(time.Duration(info.period) * time.Millisecond)
-	LOOP:
-		for {
-			select {
-			case <-info.exitCh:
-				log.Printf("[D]exit current module\n")
-				break LOOP
-			case <-mgr.ctx.Done():
-				log.Printf("[D]context done, module(%s) exit\n", info.alias)
-				break LOOP
-			case <-timer.C:
-				err = info.m.RunOnce(mgr.ctx)
-				if err != nil {
-					log.Printf("[D]module RunOnce accur err(%v), exit module\n", err)
-					mgr.UnRegister(info.m)
-					if info.onModuleError != nil {
-						fleets.Submit(func() {
-							info.onModuleError(info.m, err)
-						})
-					}
-					break LOOP
-				}
-				timer.Reset(time.Duration(info.period) * time.Millisecond)
-			}
-		}
-		mgr.wg.Done()
+		defer func() {
+			cancel()
+			close(done)
+			mgr.wg.Done()
+		}()
+		mgr.schedule(ctx, info, runID)
 	}()
 }
+
+// stopModule cancels the module's RunOnce loop and waits for it to exit.
+// It must not be called from the module's own goroutine.
+func (mgr *ModuleMgr) stopModule(info *moduleInfo) {
+	info.runMux.Lock()
+	cancel, done := info.cancel, info.done
+	info.runMux.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	if done != nil {
+		<-done
+	}
+}
+
+// submit runs task on the manager's goroutine pool.
+func (mgr *ModuleMgr) submit(task func()) {
+	var err error
+	if mgr.pool != nil {
+		err = mgr.pool.Submit(task)
+	} else {
+		err = fleets.Submit(task)
+	}
+	if err != nil {
+		log.Printf("[E]module manager(%s) submit task failed:%v\n", mgr.name, err)
+	}
+}
+
+// context returns the context of the manager and its cancel func, nil
+// until the manager is started.
+func (mgr *ModuleMgr) context() (context.Context, context.CancelFunc) {
+	mgr.ctxMux.RLock()
+	defer mgr.ctxMux.RUnlock()
+	return mgr.ctx, mgr.ctxCancelFunc
+}
+
+// start gives the manager a context derived from parent unless it has one.
+func (mgr *ModuleMgr) start(parent context.Context) {
+	mgr.ctxMux.Lock()
+	if mgr.ctx == nil {
+		mgr.ctx, mgr.ctxCancelFunc = context.WithCancel(parent)
+	}
+	mgr.ctxMux.Unlock()
+}
+
+// reset makes a shut down manager ready to be initialized again.
+func (mgr *ModuleMgr) reset() {
+	if _, cancel := mgr.context(); cancel != nil {
+		cancel()
+	}
+	mgr.restartMux.Lock()
+	mgr.ctxMux.Lock()
+	mgr.ctx, mgr.ctxCancelFunc = nil, nil
+	mgr.ctxMux.Unlock()
+	mgr.initOnce = sync.Once{}
+	mgr.shutdownOnce = sync.Once{}
+	mgr.shutdownCtx, mgr.shutdownCancel = nil, nil
+	mgr.supervisor.restarts = nil
+	mgr.restartMux.Unlock()
+}
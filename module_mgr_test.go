@@ -0,0 +1,507 @@
+package mrun
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordModule struct {
+	name string
+	rec  *recorder
+}
+
+type recorder struct {
+	sync.Mutex
+	events []string
+}
+
+func (r *recorder) add(event string) {
+	r.Lock()
+	r.events = append(r.events, event)
+	r.Unlock()
+}
+
+func (r *recorder) String() string {
+	r.Lock()
+	defer r.Unlock()
+	return strings.Join(r.events, ",")
+}
+
+func (m *recordModule) Init(args ...any) error {
+	m.rec.add("init:" + m.name)
+	return nil
+}
+func (m *recordModule) Destroy() {
+	m.rec.add("destroy:" + m.name)
+}
+func (m *recordModule) RunOnce(ctx context.Context) error {
+	return nil
+}
+func (m *recordModule) UserData() any {
+	return nil
+}
+
+func TestModuleMgrDependsOn(t *testing.T) {
+	rec := &recorder{}
+	mgr := NewModuleMgr("test")
+	err := mgr.Register(&recordModule{name: "consumer", rec: rec}, []ModuleMgrOption{
+		NewModuleAliasOption("consumer"), NewModuleDependsOnOption("cache"),
+	})
+	if err != nil {
+		t.Fatalf("register consumer failed:%v", err)
+	}
+	err = mgr.Register(&recordModule{name: "cache", rec: rec}, []ModuleMgrOption{
+		NewModuleAliasOption("cache"), NewModuleDependsOnOption("db"),
+	})
+	if err != nil {
+		t.Fatalf("register cache failed:%v", err)
+	}
+	err = mgr.Register(&recordModule{name: "db", rec: rec}, []ModuleMgrOption{NewModuleAliasOption("db")})
+	if err != nil {
+		t.Fatalf("register db failed:%v", err)
+	}
+
+	if err = mgr.Init(); err != nil {
+		t.Fatalf("init failed:%v", err)
+	}
+	mgr.Destroy()
+
+	want := "init:db,init:cache,init:consumer,destroy:consumer,destroy:cache,destroy:db"
+	if got := rec.String(); got != want {
+		t.Fatalf("events=%s, want %s", got, want)
+	}
+}
+
+func TestModuleMgrDependsOnCycle(t *testing.T) {
+	rec := &recorder{}
+	mgr := NewModuleMgr("test")
+	err := mgr.Register(&recordModule{name: "a", rec: rec}, []ModuleMgrOption{
+		NewModuleAliasOption("a"), NewModuleDependsOnOption("b"),
+	})
+	if err != nil {
+		t.Fatalf("register a failed:%v", err)
+	}
+	err = mgr.Register(&recordModule{name: "b", rec: rec}, []ModuleMgrOption{
+		NewModuleAliasOption("b"), NewModuleDependsOnOption("a"),
+	})
+	if err == nil {
+		t.Fatal("register cycle should fail")
+	}
+	err = mgr.Register(&recordModule{name: "c", rec: rec}, []ModuleMgrOption{
+		NewModuleAliasOption("c"), NewModuleDependsOnOption("c"),
+	})
+	if err == nil {
+		t.Fatal("register self dependency should fail")
+	}
+}
+
+func TestModuleMgrDependsOnMissing(t *testing.T) {
+	rec := &recorder{}
+	mgr := NewModuleMgr("test")
+	err := mgr.Register(&recordModule{name: "a", rec: rec}, []ModuleMgrOption{
+		NewModuleAliasOption("a"), NewModuleDependsOnOption("missing"),
+	})
+	if err != nil {
+		t.Fatalf("register a failed:%v", err)
+	}
+	err = mgr.Init()
+	if err == nil || err.Error() != "module(a) depends on missing module(missing)" {
+		t.Fatalf("init with missing dependency should name the dependent module, got %v", err)
+	}
+	if rec.String() != "" {
+		t.Fatalf("no module should be initialized, got %s", rec.String())
+	}
+
+	// an unaliased module is named by its type
+	mgr = NewModuleMgr("test")
+	if err = mgr.Register(&flakyModule{}, []ModuleMgrOption{NewModuleDependsOnOption("missing")}); err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	err = mgr.Init()
+	if err == nil || err.Error() != "module(*mrun.flakyModule) depends on missing module(missing)" {
+		t.Fatalf("init with missing dependency should name the dependent module, got %v", err)
+	}
+}
+
+type flakyModule struct {
+	sync.Mutex
+	inits    int
+	destroys int
+	fails    int
+}
+
+func (m *flakyModule) Init(args ...any) error {
+	m.Lock()
+	m.inits++
+	m.Unlock()
+	return nil
+}
+func (m *flakyModule) Destroy() {
+	m.Lock()
+	m.destroys++
+	m.Unlock()
+}
+func (m *flakyModule) RunOnce(ctx context.Context) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.fails > 0 {
+		m.fails--
+		return errors.New("transient failure")
+	}
+	return nil
+}
+func (m *flakyModule) UserData() any {
+	return nil
+}
+
+func (m *flakyModule) counts() (int, int) {
+	m.Lock()
+	defer m.Unlock()
+	return m.inits, m.destroys
+}
+
+func TestModuleMgrRestartOneForOne(t *testing.T) {
+	mgr := NewModuleMgr("test",
+		NewSupervisorStrategyOption(RestartOneForOne),
+		NewSupervisorBackoffOption(time.Millisecond, 10*time.Millisecond),
+	)
+	flaky := &flakyModule{fails: 2}
+	stable := &flakyModule{}
+	if err := mgr.Register(flaky, nil); err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	if err := mgr.Register(stable, nil); err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	if err := mgr.Init(); err != nil {
+		t.Fatalf("init failed:%v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if inits, _ := flaky.counts(); inits == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("module was not restarted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !mgr.Contains(flaky) {
+		t.Fatal("restarted module should stay registered")
+	}
+	if inits, _ := stable.counts(); inits != 1 {
+		t.Fatalf("stable module inits=%d, want 1", inits)
+	}
+	mgr.Destroy()
+	if inits, destroys := flaky.counts(); inits != destroys {
+		t.Fatalf("inits=%d destroys=%d", inits, destroys)
+	}
+}
+
+func TestModuleMgrRestartBackoffConcurrent(t *testing.T) {
+	backoff := 300 * time.Millisecond
+	mgr := NewModuleMgr("test",
+		NewSupervisorStrategyOption(RestartOneForOne),
+		NewSupervisorBackoffOption(backoff, backoff),
+	)
+	first := &flakyModule{fails: 1}
+	second := &flakyModule{fails: 1}
+	if err := mgr.Register(first, nil); err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	if err := mgr.Register(second, nil); err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	start := time.Now()
+	if err := mgr.Init(); err != nil {
+		t.Fatalf("init failed:%v", err)
+	}
+	defer mgr.Destroy()
+
+	time.Sleep(20 * time.Millisecond)
+	supervised := time.Now()
+	mgr.Supervise(NewSupervisorMaxRestartsOption(10, time.Minute))
+	if d := time.Since(supervised); d > backoff/2 {
+		t.Fatalf("Supervise blocked %v by a module backing off", d)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		i1, _ := first.counts()
+		i2, _ := second.counts()
+		if i1 == 2 && i2 == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("modules were not restarted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// both backed off at the same time rather than one after the other
+	if d := time.Since(start); d >= 2*backoff {
+		t.Fatalf("restarts took %v, serialized by the backoff", d)
+	}
+}
+
+func TestModuleMgrRestartGiveUp(t *testing.T) {
+	mgr := NewModuleMgr("test",
+		NewSupervisorStrategyOption(RestartOneForAll),
+		NewSupervisorMaxRestartsOption(2, time.Minute),
+		NewSupervisorBackoffOption(0, 0),
+	)
+	flaky := &flakyModule{fails: 100}
+	if err := mgr.Register(flaky, nil); err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	if err := mgr.Init(); err != nil {
+		t.Fatalf("init failed:%v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for mgr.Contains(flaky) {
+		if time.Now().After(deadline) {
+			t.Fatal("module should be unregistered after too many restarts")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mgr.Destroy()
+	if inits, destroys := flaky.counts(); inits != 3 || destroys != 3 {
+		t.Fatalf("inits=%d destroys=%d, want 3 and 3", inits, destroys)
+	}
+}
+
+type panicModule struct {
+	flakyModule
+}
+
+func (m *panicModule) RunOnce(ctx context.Context) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.fails > 0 {
+		m.fails--
+		panic("buggy module")
+	}
+	return nil
+}
+
+func TestModuleMgrPanicPolicy(t *testing.T) {
+	mgr := NewModuleMgr("test", NewSupervisorBackoffOption(0, 0))
+	restarted := &panicModule{flakyModule{fails: 1}}
+	unregistered := &panicModule{flakyModule{fails: 1}}
+	errCh := make(chan error, 2)
+	onError := NewModuleErrorOption(func(m IModule, err error) {
+		errCh <- err
+	})
+	err := mgr.Register(restarted, []ModuleMgrOption{onError, NewModulePanicPolicyOption(PanicRestart)})
+	if err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	err = mgr.Register(unregistered, []ModuleMgrOption{onError, NewModulePanicPolicyOption(PanicUnregister)})
+	if err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	if err = mgr.Init(); err != nil {
+		t.Fatalf("init failed:%v", err)
+	}
+	defer mgr.Destroy()
+
+	for range 2 {
+		select {
+		case err := <-errCh:
+			var perr *PanicError
+			if !errors.As(err, &perr) || perr.Value != "buggy module" || len(perr.Stack) == 0 {
+				t.Fatalf("unexpected error:%v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("panic was not reported")
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for mgr.Contains(unregistered) {
+		if time.Now().After(deadline) {
+			t.Fatal("panicking module should be unregistered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for {
+		if inits, _ := restarted.counts(); inits == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("panicking module should be restarted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestModuleMgrStatus(t *testing.T) {
+	mgr := NewModuleMgr("test", NewSupervisorStrategyOption(RestartOneForOne), NewSupervisorBackoffOption(0, 0))
+	flaky := &flakyModule{fails: 1}
+	err := mgr.Register(flaky, []ModuleMgrOption{NewModuleAliasOption("flaky")})
+	if err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	status, ok := mgr.ModuleStatus(flaky)
+	if !ok || status.State != ModuleRegistered {
+		t.Fatalf("state=%s, want registered", status.State)
+	}
+	if err = mgr.Init(); err != nil {
+		t.Fatalf("init failed:%v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		status, _ = mgr.ModuleStatus(flaky)
+		if status.State == ModuleRunning && status.Restarts == 1 && status.RunCount > 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected status:%+v", status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if status.LastError == nil || status.Alias != "flaky" || status.Uptime <= 0 {
+		t.Fatalf("unexpected status:%+v", status)
+	}
+	if all := mgr.Status(); len(all) != 1 || all[0].Module != flaky {
+		t.Fatalf("unexpected status list:%+v", all)
+	}
+	mgr.Destroy()
+	if all := mgr.Status(); len(all) != 0 {
+		t.Fatalf("destroyed manager should have no module, got %+v", all)
+	}
+}
+
+type countModule struct {
+	flakyModule
+	runs chan struct{}
+}
+
+func (m *countModule) RunOnce(ctx context.Context) error {
+	m.runs <- struct{}{}
+	return nil
+}
+
+type blockingModule struct {
+	flakyModule
+	exited chan struct{}
+}
+
+func (m *blockingModule) RunOnce(ctx context.Context) error {
+	<-ctx.Done()
+	close(m.exited)
+	return ctx.Err()
+}
+
+func TestModuleMgrSchedule(t *testing.T) {
+	mgr := NewModuleMgr("test")
+	trigger := make(chan struct{})
+	triggered := &countModule{runs: make(chan struct{}, 10)}
+	blocking := &blockingModule{exited: make(chan struct{})}
+	err := mgr.Register(triggered, []ModuleMgrOption{NewModuleRunTriggerOption(trigger)})
+	if err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	err = mgr.Register(blocking, []ModuleMgrOption{NewModuleRunBlockingOption()})
+	if err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	err = mgr.Register(&flakyModule{}, []ModuleMgrOption{NewModuleRunCronOption("61 * * * *")})
+	if err == nil {
+		t.Fatal("register with invalid cron expression should fail")
+	}
+	if err = mgr.Init(); err != nil {
+		t.Fatalf("init failed:%v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if len(triggered.runs) != 0 {
+		t.Fatal("module should not run before trigger fires")
+	}
+	trigger <- struct{}{}
+	trigger <- struct{}{}
+	for range 2 {
+		select {
+		case <-triggered.runs:
+		case <-time.After(2 * time.Second):
+			t.Fatal("module should run when trigger fires")
+		}
+	}
+
+	mgr.Destroy()
+	select {
+	case <-blocking.exited:
+	default:
+		t.Fatal("blocking RunOnce should return on destroy")
+	}
+	if _, destroys := blocking.counts(); destroys != 1 {
+		t.Fatalf("blocking module destroys=%d, want 1", destroys)
+	}
+}
+
+type drainModule struct {
+	recordModule
+	release chan struct{}
+}
+
+func (m *drainModule) Drain(ctx context.Context) error {
+	m.rec.add("drain:" + m.name)
+	return nil
+}
+
+func (m *drainModule) RunOnce(ctx context.Context) error {
+	if m.release != nil {
+		// ignores ctx on purpose
+		<-m.release
+	}
+	return nil
+}
+
+func TestModuleMgrShutdown(t *testing.T) {
+	rec := &recorder{}
+	mgr := NewModuleMgr("test")
+	stuck := &drainModule{recordModule: recordModule{name: "stuck", rec: rec}, release: make(chan struct{})}
+	err := mgr.Register(&drainModule{recordModule: recordModule{name: "db", rec: rec}},
+		[]ModuleMgrOption{NewModuleAliasOption("db")})
+	if err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	err = mgr.Register(stuck, []ModuleMgrOption{
+		NewModuleAliasOption("stuck"),
+		NewModuleDependsOnOption("db"),
+		NewModuleStopTimeoutOption(20 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	if err = mgr.Init(); err != nil {
+		t.Fatalf("init failed:%v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = mgr.Shutdown(ctx)
+	var serr *ShutdownError
+	if !errors.As(err, &serr) || len(serr.Modules) != 1 || serr.Modules[0].Module != stuck {
+		t.Fatalf("unexpected shutdown error:%v", err)
+	}
+	want := "init:db,init:stuck,drain:stuck,drain:db,destroy:db"
+	if got := rec.String(); got != want {
+		t.Fatalf("events=%s, want %s", got, want)
+	}
+	if !mgr.Contains(stuck) {
+		t.Fatal("module failing to stop should stay registered")
+	}
+
+	close(stuck.release)
+	mgr.Destroy()
+	if got := rec.String(); got != want+",destroy:stuck" {
+		t.Fatalf("events=%s after destroy, want %s,destroy:stuck", got, want)
+	}
+}
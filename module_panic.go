@@ -0,0 +1,57 @@
+package mrun
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime"
+)
+
+// PanicPolicy decides what happens when a module's RunOnce panics.
+type PanicPolicy int
+
+const (
+	// PanicCrash lets the panic crash the process, it's the default policy.
+	PanicCrash PanicPolicy = iota
+	// PanicRestart recovers the panic and restarts the module following the
+	// manager's restart strategy, one-for-one if the manager has none.
+	PanicRestart
+	// PanicUnregister recovers the panic and unregisters the module.
+	PanicUnregister
+)
+
+// PanicError is the error a recovered RunOnce panic is converted to, it's
+// passed to the callback of NewModuleErrorOption.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n%s", e.Value, e.Stack)
+}
+
+func NewModulePanicPolicyOption(policy PanicPolicy) func(mgr *ModuleMgr, info *moduleInfo) {
+	return func(mgr *ModuleMgr, info *moduleInfo) {
+		if policy < PanicCrash || policy > PanicUnregister {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		info.panicPolicy = policy
+	}
+}
+
+// runOnce calls the module's RunOnce, converting a panic to a *PanicError
+// unless the module's policy is PanicCrash.
+func (mgr *ModuleMgr) runOnce(ctx context.Context, info *moduleInfo) (err error) {
+	if info.panicPolicy != PanicCrash {
+		defer func() {
+			if r := recover(); r != nil {
+				buf := make([]byte, 4096)
+				l := runtime.Stack(buf, false)
+				err = &PanicError{Value: r, Stack: buf[:l]}
+			}
+		}()
+	}
+	return info.m.RunOnce(ctx)
+}
@@ -0,0 +1,168 @@
+package mrun
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+type scheduleMode int
+
+const (
+	// RunOnce is called period milliseconds after the previous call returned.
+	scheduleFixedDelay scheduleMode = iota
+	// RunOnce is called every period milliseconds, missed ticks are dropped.
+	scheduleFixedRate
+	// RunOnce is called once and owns its loop until the context is done.
+	scheduleBlocking
+	// RunOnce is called at the times matching a cron expression.
+	scheduleCron
+	// RunOnce is called every time the trigger channel fires.
+	scheduleTrigger
+)
+
+// NewModuleRunRateOption calls RunOnce at a fixed rate of one call every
+// msec milliseconds, measured from the start of each call. A call that
+// overruns the period delays the next one instead of queueing it.
+func NewModuleRunRateOption(msec uint) func(mgr *ModuleMgr, info *moduleInfo) {
+	return func(mgr *ModuleMgr, info *moduleInfo) {
+		if msec == 0 {
+			msec = 1
+		}
+		info.schedule = scheduleFixedRate
+		info.period = msec
+	}
+}
+
+// NewModuleRunBlockingOption calls RunOnce a single time. RunOnce is expected
+// to run its own loop and return once its context is done.
+func NewModuleRunBlockingOption() func(mgr *ModuleMgr, info *moduleInfo) {
+	return func(mgr *ModuleMgr, info *moduleInfo) {
+		info.schedule = scheduleBlocking
+	}
+}
+
+// NewModuleRunCronOption calls RunOnce at the times matching expr, a standard
+// 5 fields cron expression (minute hour day-of-month month day-of-week) or
+// one of @yearly, @monthly, @weekly, @daily and @hourly. Times are local.
+func NewModuleRunCronOption(expr string) func(mgr *ModuleMgr, info *moduleInfo) {
+	return func(mgr *ModuleMgr, info *moduleInfo) {
+		c, err := parseCron(expr)
+		if err != nil {
+			log.Printf("[E]invalid arg:%v\n", err)
+			info.optionErr = err
+			return
+		}
+		info.schedule = scheduleCron
+		info.cron = c
+	}
+}
+
+// NewModuleRunTriggerOption calls RunOnce only when trigger fires. The module
+// exits once trigger is closed.
+func NewModuleRunTriggerOption(trigger <-chan struct{}) func(mgr *ModuleMgr, info *moduleInfo) {
+	return func(mgr *ModuleMgr, info *moduleInfo) {
+		if trigger == nil {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		info.schedule = scheduleTrigger
+		info.trigger = trigger
+	}
+}
+
+// schedule calls the module's RunOnce according to its schedule mode until
+// ctx is done or RunOnce fails.
+func (mgr *ModuleMgr) schedule(ctx context.Context, info *moduleInfo, runID uint64) {
+	switch info.schedule {
+	case scheduleBlocking:
+		if mgr.step(ctx, info, runID) && ctx.Err() == nil {
+			log.Printf("[D]module(%s) RunOnce returned, module exit\n", info.alias)
+			info.finish()
+		}
+		return
+	case scheduleTrigger:
+		for {
+			select {
+			case <-ctx.Done():
+				log.Printf("[D]context done, module(%s) exit\n", info.alias)
+				return
+			case _, ok := <-info.trigger:
+				if !ok {
+					log.Printf("[D]trigger closed, module(%s) exit\n", info.alias)
+					info.finish()
+					return
+				}
+				if !mgr.step(ctx, info, runID) {
+					return
+				}
+			}
+		}
+	case scheduleFixedRate:
+		ticker := time.NewTicker(time.Duration(info.period) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Printf("[D]context done, module(%s) exit\n", info.alias)
+				return
+			case <-ticker.C:
+				if !mgr.step(ctx, info, runID) {
+					return
+				}
+			}
+		}
+	case scheduleCron:
+		for {
+			next := info.cron.next(time.Now())
+			if next.IsZero() {
+				log.Printf("[E]module(%s) cron schedule never fires, module exit\n", info.alias)
+				info.setState(ModuleStopped, nil)
+				return
+			}
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				log.Printf("[D]context done, module(%s) exit\n", info.alias)
+				return
+			case <-timer.C:
+				if !mgr.step(ctx, info, runID) {
+					return
+				}
+			}
+		}
+	default:
+		timer := time.NewTimer(time.Duration(info.period) * time.Millisecond)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Printf("[D]context done, module(%s) exit\n", info.alias)
+				return
+			case <-timer.C:
+				if !mgr.step(ctx, info, runID) {
+					return
+				}
+				timer.Reset(time.Duration(info.period) * time.Millisecond)
+			}
+		}
+	}
+}
+
+// step calls RunOnce once and reports whether the module keeps running.
+func (mgr *ModuleMgr) step(ctx context.Context, info *moduleInfo, runID uint64) bool {
+	start := time.Now()
+	err := mgr.runOnce(ctx, info)
+	info.recordRun(time.Since(start))
+	if err != nil {
+		if ctx.Err() != nil {
+			log.Printf("[D]context done, module(%s) exit\n", info.alias)
+			return false
+		}
+		info.setState(ModuleFailed, err)
+		mgr.onModuleFailed(info, runID, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,211 @@
+package mrun
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_SHUTDOWN_GRACE_PERIOD = 30 * time.Second
+)
+
+// ShutdownError is returned by ModuleMgr.Shutdown when some modules didn't
+// stop before their deadline. Those modules stay registered, a later
+// ModuleMgr.Destroy finishes them.
+type ShutdownError struct {
+	Modules []ModuleStatus
+}
+
+func (e *ShutdownError) Error() string {
+	names := make([]string, 0, len(e.Modules))
+	for _, v := range e.Modules {
+		names = append(names, fmt.Sprintf("%s(%T)", v.Alias, v.Module))
+	}
+	return fmt.Sprintf("modules %s failed to stop in time", strings.Join(names, ","))
+}
+
+// NewModuleStopTimeoutOption bounds the time ModuleMgr.Shutdown waits for the
+// module to drain, leave its RunOnce loop and be destroyed.
+func NewModuleStopTimeoutOption(timeout time.Duration) func(mgr *ModuleMgr, info *moduleInfo) {
+	return func(mgr *ModuleMgr, info *moduleInfo) {
+		if timeout <= 0 {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		info.stopTimeout = timeout
+	}
+}
+
+// shutdownContext returns the context canceled once Shutdown starts, it
+// keeps the supervisor from restarting modules being shut down.
+func (mgr *ModuleMgr) shutdownContext() (context.Context, context.CancelFunc) {
+	mgr.shutdownOnce.Do(func() {
+		mgr.shutdownCtx, mgr.shutdownCancel = context.WithCancel(context.Background())
+	})
+	return mgr.shutdownCtx, mgr.shutdownCancel
+}
+
+// Shutdown stops the modules one by one in reverse start order. A module
+// implementing Drainer is drained first, then its RunOnce loop is stopped and
+// it's destroyed. ctx bounds the whole shutdown, NewModuleStopTimeoutOption
+// bounds each module. The modules failing to stop in time are reported by a
+// *ShutdownError.
+func (mgr *ModuleMgr) Shutdown(ctx context.Context) error {
+	_, cancel := mgr.context()
+	if cancel == nil {
+		return nil
+	}
+	_, shutdown := mgr.shutdownContext()
+	shutdown()
+
+	// wait for a pending restart to notice the shutdown
+	mgr.restartMux.Lock()
+	mgr.modulesMux.RLock()
+	if mgr.modules == nil {
+		mgr.modules = list.New()
+	}
+	infos := make([]*moduleInfo, 0, mgr.modules.Len())
+	e := mgr.modules.Front()
+	for e != nil {
+		infos = append(infos, e.Value.(*moduleInfo))
+		e = e.Next()
+	}
+	mgr.modulesMux.RUnlock()
+	mgr.restartMux.Unlock()
+
+	sorted, err := sortModules(infos, true)
+	if err != nil {
+		log.Printf("[E]sort modules failed:%v, shutdown by priority\n", err)
+		sorted = infos
+	}
+	var timedOut []ModuleStatus
+	stopped := make(map[*moduleInfo]bool, len(sorted))
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if !mgr.shutdownModule(ctx, sorted[i]) {
+			log.Printf("[E]module(%s) failed to stop in time\n", sorted[i].alias)
+			timedOut = append(timedOut, sorted[i].status())
+			continue
+		}
+		stopped[sorted[i]] = true
+	}
+
+	cancel()
+	// the modules which didn't stop are kept for Destroy
+	mgr.modulesMux.Lock()
+	e = mgr.modules.Front()
+	for e != nil {
+		next := e.Next()
+		if stopped[e.Value.(*moduleInfo)] {
+			mgr.modules.Remove(e)
+		}
+		e = next
+	}
+	mgr.modulesMux.Unlock()
+	if len(timedOut) > 0 {
+		// their goroutines are still running, a Wait left behind would
+		// race with the next run
+		return &ShutdownError{Modules: timedOut}
+	}
+	if !waitContext(ctx, mgr.wg.Wait) {
+		log.Printf("[E]module manager(%s) wait goroutines exit timeout\n", mgr.name)
+	}
+	return nil
+}
+
+// shutdownModule drains, stops and destroys a module, it returns false if the
+// module didn't stop before the deadline.
+func (mgr *ModuleMgr) shutdownModule(ctx context.Context, info *moduleInfo) bool {
+	if info.stopTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, info.stopTimeout)
+		defer cancel()
+	}
+	info.runMux.Lock()
+	if info.destroyed {
+		info.runMux.Unlock()
+		return true
+	}
+	info.state = ModuleStopping
+	info.finished = false
+	info.runMux.Unlock()
+
+	if d, ok := info.m.(Drainer); ok {
+		var err error
+		if !waitContext(ctx, func() { err = d.Drain(ctx) }) {
+			return false
+		}
+		if err != nil {
+			log.Printf("[E]module(%s) drain failed:%v\n", info.alias, err)
+			info.setState(ModuleStopping, err)
+		}
+	}
+
+	info.runMux.Lock()
+	cancel, done := info.cancel, info.done
+	info.runMux.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	if done != nil && !waitContext(ctx, func() { <-done }) {
+		return false
+	}
+
+	info.runMux.Lock()
+	destroyed := info.destroyed
+	info.destroyed = true
+	info.runMux.Unlock()
+	if !destroyed && !waitContext(ctx, func() { mgr.destroy(info) }) {
+		return false
+	}
+	info.setState(ModuleStopped, nil)
+	return true
+}
+
+// forget unregisters a module which failed to stop in time without waiting
+// for it, it's destroyed in the background once its RunOnce loop returned.
+func (mgr *ModuleMgr) forget(m IModule) {
+	info := mgr.GetModuleInfo(m)
+	if info == nil {
+		return
+	}
+	mgr.DeleteModuleInfo(m)
+	info.runMux.Lock()
+	cancel, done := info.cancel, info.done
+	info.runMux.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	go func() {
+		if done != nil {
+			<-done
+		}
+		info.runMux.Lock()
+		destroyed := info.destroyed
+		info.destroyed = true
+		info.runMux.Unlock()
+		if !destroyed {
+			mgr.destroy(info)
+		}
+		info.setState(ModuleStopped, nil)
+	}()
+}
+
+// waitContext runs fn and waits for it to return until ctx is done. fn keeps
+// running in the background once ctx is done.
+func waitContext(ctx context.Context, fn func()) bool {
+	finished := make(chan struct{})
+	go func() {
+		fn()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,138 @@
+package mrun
+
+import (
+	"log"
+	"time"
+)
+
+// ModuleState is the lifecycle state of a registered module.
+type ModuleState int
+
+const (
+	ModuleRegistered ModuleState = iota
+	ModuleInitializing
+	ModuleRunning
+	ModuleStopping
+	ModuleStopped
+	ModuleFailed
+)
+
+func (s ModuleState) String() string {
+	switch s {
+	case ModuleRegistered:
+		return "registered"
+	case ModuleInitializing:
+		return "initializing"
+	case ModuleRunning:
+		return "running"
+	case ModuleStopping:
+		return "stopping"
+	case ModuleStopped:
+		return "stopped"
+	case ModuleFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// ModuleStatus is a snapshot of a module's state and statistics.
+type ModuleStatus struct {
+	Module          IModule
+	Alias           string
+	State           ModuleState
+	LastError       error
+	Restarts        int
+	RunCount        uint64
+	LastRunDuration time.Duration
+	Uptime          time.Duration
+	// Finished is set when a stopped blocking or trigger module ended on
+	// its own: RunOnce returned or the trigger was closed.
+	Finished bool
+}
+
+// Status returns the status of every registered module.
+func (mgr *ModuleMgr) Status() []ModuleStatus {
+	mgr.modulesMux.RLock()
+	if mgr.modules == nil {
+		mgr.modulesMux.RUnlock()
+		return nil
+	}
+	ret := make([]ModuleStatus, 0, mgr.modules.Len())
+	e := mgr.modules.Front()
+	for e != nil {
+		ret = append(ret, e.Value.(*moduleInfo).status())
+		e = e.Next()
+	}
+	mgr.modulesMux.RUnlock()
+	return ret
+}
+
+// ModuleStatus returns the status of m.
+func (mgr *ModuleMgr) ModuleStatus(m IModule) (ModuleStatus, bool) {
+	info := mgr.GetModuleInfo(m)
+	if info == nil {
+		return ModuleStatus{}, false
+	}
+	return info.status(), true
+}
+
+func (info *moduleInfo) status() ModuleStatus {
+	info.runMux.Lock()
+	defer info.runMux.Unlock()
+	s := ModuleStatus{
+		Module:          info.m,
+		Alias:           info.alias,
+		State:           info.state,
+		LastError:       info.lastErr,
+		Restarts:        info.restarts,
+		RunCount:        info.runCount,
+		LastRunDuration: info.lastRunDuration,
+		Finished:        info.finished,
+	}
+	if info.state == ModuleRunning {
+		s.Uptime = time.Since(info.startedAt)
+	}
+	return s
+}
+
+func (info *moduleInfo) setState(state ModuleState, err error) {
+	info.runMux.Lock()
+	info.state = state
+	info.finished = false
+	if err != nil {
+		info.lastErr = err
+	}
+	if state == ModuleRunning {
+		info.startedAt = time.Now()
+	}
+	info.runMux.Unlock()
+}
+
+// finish stops a module which ended on its own.
+func (info *moduleInfo) finish() {
+	info.runMux.Lock()
+	info.state = ModuleStopped
+	info.finished = true
+	info.runMux.Unlock()
+}
+
+func (info *moduleInfo) recordRun(d time.Duration) {
+	info.runMux.Lock()
+	info.runCount++
+	info.lastRunDuration = d
+	info.runMux.Unlock()
+}
+
+// initModule calls the module's Init with its stored args and tracks the
+// state transition.
+func (mgr *ModuleMgr) initModule(info *moduleInfo) error {
+	info.setState(ModuleInitializing, nil)
+	err := info.m.Init(info.args...)
+	if err != nil {
+		log.Printf("[E]module init failed:%v\n", err)
+		info.setState(ModuleFailed, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package mrun
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// RestartStrategy decides which modules are restarted when a module's
+// RunOnce returns an error.
+type RestartStrategy int
+
+const (
+	// RestartNone unregisters the failed module, it's the default strategy.
+	RestartNone RestartStrategy = iota
+	// RestartOneForOne restarts only the failed module.
+	RestartOneForOne
+	// RestartOneForAll restarts every module of the manager.
+	RestartOneForAll
+	// RestartRestForOne restarts the failed module and every module started
+	// after it.
+	RestartRestForOne
+)
+
+const (
+	DEFAULT_SUPERVISOR_MAX_RESTARTS = 3
+	DEFAULT_SUPERVISOR_WINDOW       = 5 * time.Second
+	DEFAULT_SUPERVISOR_MIN_BACKOFF  = 100 * time.Millisecond
+	DEFAULT_SUPERVISOR_MAX_BACKOFF  = 10 * time.Second
+)
+
+type supervisor struct {
+	strategy    RestartStrategy
+	maxRestarts int
+	window      time.Duration
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	restarts    []time.Time
+}
+
+type SupervisorOption func(*supervisor)
+
+func NewSupervisorStrategyOption(strategy RestartStrategy) func(*supervisor) {
+	return func(s *supervisor) {
+		if strategy < RestartNone || strategy > RestartRestForOne {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		s.strategy = strategy
+	}
+}
+
+// NewSupervisorMaxRestartsOption limits the restarts to max within window.
+// Once the limit is exceeded the failed module is unregistered.
+func NewSupervisorMaxRestartsOption(max int, window time.Duration) func(*supervisor) {
+	return func(s *supervisor) {
+		if max <= 0 || window <= 0 {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		s.maxRestarts = max
+		s.window = window
+	}
+}
+
+// NewSupervisorBackoffOption sets the delay before a restart, it starts at
+// min and doubles with every restart within the window up to max.
+func NewSupervisorBackoffOption(min, max time.Duration) func(*supervisor) {
+	return func(s *supervisor) {
+		if min < 0 || max < min {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		s.minBackoff = min
+		s.maxBackoff = max
+	}
+}
+
+// Supervise sets the restart policy of the manager.
+func (mgr *ModuleMgr) Supervise(options ...SupervisorOption) {
+	mgr.restartMux.Lock()
+	defer mgr.restartMux.Unlock()
+	mgr.supervisor.setDefaults()
+	for _, option := range options {
+		option(&mgr.supervisor)
+	}
+}
+
+func (s *supervisor) setDefaults() {
+	if s.maxRestarts == 0 {
+		*s = supervisor{
+			strategy:    s.strategy,
+			maxRestarts: DEFAULT_SUPERVISOR_MAX_RESTARTS,
+			window:      DEFAULT_SUPERVISOR_WINDOW,
+			minBackoff:  DEFAULT_SUPERVISOR_MIN_BACKOFF,
+			maxBackoff:  DEFAULT_SUPERVISOR_MAX_BACKOFF,
+		}
+	}
+}
+
+// backoff returns the delay before the next restart, n is the number of
+// restarts already done within the window.
+func (s *supervisor) backoff(n int) time.Duration {
+	delay := s.minBackoff
+	for range n {
+		if delay >= s.maxBackoff/2 {
+			return s.maxBackoff
+		}
+		delay *= 2
+	}
+	return min(delay, s.maxBackoff)
+}
+
+// onModuleFailed is called from the module's goroutine once RunOnce failed,
+// the goroutine exits right after it.
+func (mgr *ModuleMgr) onModuleFailed(info *moduleInfo, runID uint64, err error) {
+	if info.onModuleError != nil {
+		mgr.submit(func() {
+			info.onModuleError(info.m, err)
+		})
+	}
+
+	shutdownCtx, _ := mgr.shutdownContext()
+	if shutdownCtx.Err() != nil {
+		// the module is stopped by Shutdown
+		return
+	}
+	mgr.restartMux.Lock()
+	restart := mgr.supervisor.strategy != RestartNone
+	mgr.restartMux.Unlock()
+	var perr *PanicError
+	if errors.As(err, &perr) {
+		log.Printf("[E]module(%s) RunOnce panic:%v: %s\n", info.alias, perr.Value, perr.Stack)
+		restart = info.panicPolicy == PanicRestart
+	}
+	if !restart {
+		log.Printf("[D]module RunOnce accur err(%v), exit module\n", err)
+		mgr.UnRegister(info.m)
+		return
+	}
+
+	log.Printf("[D]module(%s) RunOnce accur err(%v), restart module\n", info.alias, err)
+	mgr.wg.Add(1)
+	go func() {
+		defer mgr.wg.Done()
+		mgr.restart(info, runID)
+	}()
+}
+
+// restartable reports whether the run runID of info is still the one to
+// restart, mgr.restartMux must be held.
+func (mgr *ModuleMgr) restartable(shutdownCtx context.Context, info *moduleInfo, runID uint64) bool {
+	if shutdownCtx.Err() != nil {
+		return false
+	}
+	info.runMux.Lock()
+	stale := info.runID != runID
+	info.runMux.Unlock()
+	// already restarted or unregistered by someone else
+	return !stale && mgr.Contains(info.m)
+}
+
+func (mgr *ModuleMgr) restart(info *moduleInfo, runID uint64) {
+	ctx, _ := mgr.context()
+	if ctx == nil {
+		return
+	}
+	shutdownCtx, _ := mgr.shutdownContext()
+	mgr.restartMux.Lock()
+	if !mgr.restartable(shutdownCtx, info, runID) {
+		mgr.restartMux.Unlock()
+		return
+	}
+
+	s := &mgr.supervisor
+	s.setDefaults()
+	now := time.Now()
+	restarts := s.restarts[:0]
+	for _, t := range s.restarts {
+		if now.Sub(t) < s.window {
+			restarts = append(restarts, t)
+		}
+	}
+	s.restarts = restarts
+	if len(s.restarts) >= s.maxRestarts {
+		log.Printf("[E]module(%s) restarted %d times within %v, give up\n", info.alias, len(s.restarts), s.window)
+		mgr.UnRegister(info.m)
+		mgr.restartMux.Unlock()
+		return
+	}
+	delay := s.backoff(len(s.restarts))
+	s.restarts = append(s.restarts, now)
+	// the other modules are supervised while this one backs off
+	mgr.restartMux.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return
+	case <-shutdownCtx.Done():
+		return
+	case <-timer.C:
+	}
+
+	mgr.restartMux.Lock()
+	defer mgr.restartMux.Unlock()
+	if ctx.Err() != nil || !mgr.restartable(shutdownCtx, info, runID) {
+		return
+	}
+
+	infos := mgr.restartSet(info)
+	for i := len(infos) - 1; i >= 0; i-- {
+		infos[i].runMux.Lock()
+		if infos[i].state == ModuleRunning {
+			infos[i].state = ModuleStopping
+		}
+		infos[i].runMux.Unlock()
+		mgr.stopModule(infos[i])
+		infos[i].runMux.Lock()
+		destroyed := infos[i].destroyed
+		infos[i].destroyed = true
+		infos[i].runMux.Unlock()
+		if !destroyed {
+			mgr.destroy(infos[i])
+		}
+		infos[i].runMux.Lock()
+		if infos[i].state == ModuleStopping {
+			infos[i].state = ModuleStopped
+		}
+		infos[i].restarts++
+		infos[i].runMux.Unlock()
+	}
+	for _, v := range infos {
+		if ctx.Err() != nil || shutdownCtx.Err() != nil {
+			return
+		}
+		if !mgr.Contains(v.m) {
+			continue
+		}
+		err := mgr.initModule(v)
+		if err != nil {
+			v.runMux.Lock()
+			id := v.runID
+			v.runMux.Unlock()
+			mgr.wg.Add(1)
+			go func() {
+				defer mgr.wg.Done()
+				mgr.restart(v, id)
+			}()
+			return
+		}
+		mgr.runModule(v)
+	}
+}
+
+// restartSet returns the modules to restart in start order.
+func (mgr *ModuleMgr) restartSet(info *moduleInfo) []*moduleInfo {
+	if mgr.supervisor.strategy == RestartNone || mgr.supervisor.strategy == RestartOneForOne {
+		return []*moduleInfo{info}
+	}
+	infos, err := mgr.sortedModules()
+	if err != nil {
+		log.Printf("[E]sort modules failed:%v, restart module(%s) only\n", err, info.alias)
+		return []*moduleInfo{info}
+	}
+	if mgr.supervisor.strategy == RestartRestForOne {
+		for i, v := range infos {
+			if v == info {
+				return infos[i:]
+			}
+		}
+	}
+	return infos
+}
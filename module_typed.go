@@ -0,0 +1,111 @@
+package mrun
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// TypedModule is a module taking a typed config instead of positional args.
+// It's registered by RegisterTypedModule or RegisterTyped, which check the
+// config type at compile time.
+type TypedModule[C any] interface {
+	Init(cfg C) error
+	Destroy()
+	RunOnce(ctx context.Context) error
+	UserData() any
+}
+
+// TypedModuleAdapter adapts a TypedModule to IModule, it's the IModule the
+// manager holds for a typed module, e.g. in Range or GetModulesByAlias.
+type TypedModuleAdapter[C any] struct {
+	m TypedModule[C]
+}
+
+type typedAdapter interface {
+	typedModule() any
+}
+
+// AsModule adapts m to IModule. Init of the adapter expects a single arg of
+// type C, or no arg for the zero config.
+func AsModule[C any](m TypedModule[C]) *TypedModuleAdapter[C] {
+	return &TypedModuleAdapter[C]{m: m}
+}
+
+// Module returns the adapted typed module.
+func (a *TypedModuleAdapter[C]) Module() TypedModule[C] {
+	return a.m
+}
+
+func (a *TypedModuleAdapter[C]) typedModule() any {
+	return a.m
+}
+
+func (a *TypedModuleAdapter[C]) Init(args ...any) error {
+	var cfg C
+	if len(args) > 1 {
+		log.Printf("[E]typed module(%T) expects 1 arg, got %d\n", a.m, len(args))
+		return fmt.Errorf("typed module(%T) expects 1 arg, got %d", a.m, len(args))
+	}
+	if len(args) == 1 {
+		var ok bool
+		cfg, ok = args[0].(C)
+		if !ok {
+			log.Printf("[E]typed module(%T) expects arg of type %T, got %T\n", a.m, cfg, args[0])
+			return fmt.Errorf("typed module(%T) expects arg of type %T, got %T", a.m, cfg, args[0])
+		}
+	}
+	return a.m.Init(cfg)
+}
+
+func (a *TypedModuleAdapter[C]) Destroy() {
+	a.m.Destroy()
+}
+
+func (a *TypedModuleAdapter[C]) RunOnce(ctx context.Context) error {
+	return a.m.RunOnce(ctx)
+}
+
+func (a *TypedModuleAdapter[C]) UserData() any {
+	return a.m.UserData()
+}
+
+// RegisterTypedModule registers m into mgr, cfg is passed to its Init.
+func RegisterTypedModule[C any](mgr *ModuleMgr, m TypedModule[C], options []ModuleMgrOption, cfg C) error {
+	if mgr == nil || m == nil {
+		log.Printf("[E]invalid arg\n")
+		return fmt.Errorf("invalid arg")
+	}
+	if mgr.typedAdapterOf(m) != nil {
+		log.Printf("[E]already register")
+		return fmt.Errorf("already register")
+	}
+	return mgr.Register(AsModule(m), options, cfg)
+}
+
+// UnRegisterTypedModule unregisters m registered by RegisterTypedModule.
+func UnRegisterTypedModule[C any](mgr *ModuleMgr, m TypedModule[C]) error {
+	if mgr == nil || m == nil {
+		log.Printf("[E]invalid arg\n")
+		return fmt.Errorf("invalid arg")
+	}
+	a := mgr.typedAdapterOf(m)
+	if a == nil {
+		log.Printf("[E]module not register")
+		return fmt.Errorf("module not register")
+	}
+	return mgr.UnRegister(a)
+}
+
+// typedAdapterOf returns the adapter registered for the typed module m.
+func (mgr *ModuleMgr) typedAdapterOf(m any) IModule {
+	var ret IModule
+	mgr.Range(func(v IModule) bool {
+		if a, ok := v.(typedAdapter); ok && a.typedModule() == m {
+			ret = v
+			return false
+		}
+		return true
+	})
+	return ret
+}
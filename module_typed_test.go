@@ -0,0 +1,76 @@
+package mrun
+
+import (
+	"testing"
+	"time"
+)
+
+type typedConfig struct {
+	Addr    string
+	Retries int
+}
+
+type typedModule struct {
+	flakyModule
+	cfg typedConfig
+}
+
+func (m *typedModule) Init(cfg typedConfig) error {
+	m.cfg = cfg
+	return m.flakyModule.Init()
+}
+
+func TestRegisterTypedModule(t *testing.T) {
+	mgr := NewModuleMgr("test", NewSupervisorStrategyOption(RestartOneForOne), NewSupervisorBackoffOption(0, 0))
+	m := &typedModule{flakyModule: flakyModule{fails: 1}}
+	err := RegisterTypedModule(mgr, m, []ModuleMgrOption{NewModuleAliasOption("typed")}, typedConfig{Addr: ":8080", Retries: 3})
+	if err != nil {
+		t.Fatalf("register failed:%v", err)
+	}
+	if err = RegisterTypedModule(mgr, m, nil, typedConfig{}); err == nil {
+		t.Fatal("register twice should fail")
+	}
+	if err = mgr.Init(); err != nil {
+		t.Fatalf("init failed:%v", err)
+	}
+	defer mgr.Destroy()
+
+	// the restart re-invokes Init with the same config
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if inits, _ := m.counts(); inits == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("module was not restarted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if m.cfg.Addr != ":8080" || m.cfg.Retries != 3 {
+		t.Fatalf("unexpected config:%+v", m.cfg)
+	}
+	a, ok := mgr.GetModulesByAlias("typed")[0].(*TypedModuleAdapter[typedConfig])
+	if !ok || a.Module() != m {
+		t.Fatal("alias should resolve to the typed module adapter")
+	}
+	if err = UnRegisterTypedModule(mgr, m); err != nil {
+		t.Fatalf("unregister failed:%v", err)
+	}
+	if mgr.ModuleNum() != 0 {
+		t.Fatal("module should be unregistered")
+	}
+}
+
+func TestTypedModuleAdapterInit(t *testing.T) {
+	m := &typedModule{}
+	a := AsModule[typedConfig](m)
+	if err := a.Init("wrong"); err == nil {
+		t.Fatal("init with wrong arg type should fail")
+	}
+	if err := a.Init(typedConfig{}, typedConfig{}); err == nil {
+		t.Fatal("init with 2 args should fail")
+	}
+	if err := a.Init(); err != nil {
+		t.Fatalf("init with zero config failed:%v", err)
+	}
+}
@@ -1,13 +1,87 @@
 package mrun
 
 import (
-	"container/list"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 )
 
+// JoinMode tells ParallelDataFlow when a fan-out is complete.
+type JoinMode int
+
+const (
+	// JoinAll waits for every processor, the call fails if any of them failed.
+	JoinAll JoinMode = iota
+	// JoinFirstSuccess returns as soon as a processor succeeded.
+	JoinFirstSuccess
+	// JoinQuorum returns as soon as quorum processors succeeded.
+	JoinQuorum
+)
+
+func (m JoinMode) String() string {
+	switch m {
+	case JoinAll:
+		return "all"
+	case JoinFirstSuccess:
+		return "first-success"
+	case JoinQuorum:
+		return "quorum"
+	default:
+		return "unknown"
+	}
+}
+
+type ParallelOption func(*ParallelDataFlow) error
+
+// NewParallelJoinOption sets the join mode, JoinAll by default. Use
+// NewParallelQuorumOption for JoinQuorum.
+func NewParallelJoinOption(mode JoinMode) func(*ParallelDataFlow) error {
+	return func(df *ParallelDataFlow) error {
+		if mode != JoinAll && mode != JoinFirstSuccess {
+			log.Printf("[E]invalid parallel join mode(%v)\n", mode)
+			return fmt.Errorf("invalid parallel join mode(%v)", mode)
+		}
+		df.join = mode
+		return nil
+	}
+}
+
+// NewParallelQuorumOption makes a call complete once n processors succeeded.
+func NewParallelQuorumOption(n int) func(*ParallelDataFlow) error {
+	return func(df *ParallelDataFlow) error {
+		if n <= 0 {
+			log.Printf("[E]invalid parallel quorum(%d)\n", n)
+			return fmt.Errorf("invalid parallel quorum(%d)", n)
+		}
+		df.join = JoinQuorum
+		df.quorum = n
+		return nil
+	}
+}
+
+// ParallelDataFlow hands every message to all its processors concurrently.
+// The output of Process and ProcessContext is a []DataFlowResult in the
+// order of the processors, each slot carrying its processor's output or
+// error. Slots whose processor didn't finish before the call completed hold
+// the context error.
 type ParallelDataFlow struct {
 	BaseDataFlow
+	join   JoinMode
+	quorum int
+}
+
+func NewParallelDataFlow(options ...ParallelOption) (*ParallelDataFlow, error) {
+	df := &ParallelDataFlow{}
+	for _, option := range options {
+		err := option(df)
+		if err != nil {
+			log.Printf("[E]run parallel option func failed:%v\n", err)
+			return nil, err
+		}
+	}
+	return df, nil
 }
 
 func (df *ParallelDataFlow) Register(p IDataProcessor, options []DataFlowOption, args ...any) error {
@@ -49,40 +123,113 @@ func (df *ParallelDataFlow) Register(p IDataProcessor, options []DataFlowOption,
 }
 
 func (df *ParallelDataFlow) Process(msg any) (any, error) {
-	df.processorsMux.RLock()
-	if df.processors == nil {
-		df.processors = list.New()
-	}
-	var outmsgs []any
-	e := df.processors.Front()
-	for e != nil {
-		err := e.Value.(*dataProcessorInfo).p.MsgCheck(msg)
-		if err != nil {
-			log.Printf("[E]MsgCheck failed:%v\n", err)
-			if e.Value.(*dataProcessorInfo).processFaildFunc != nil {
-				df.processorsMux.RUnlock()
-				return e.Value.(*dataProcessorInfo).processFaildFunc(msg, err)
+	return df.ProcessContext(df.context(), msg)
+}
+
+// ProcessContext is like Process, the call completes once ctx is done at the
+// latest. Processors implementing ContextDataProcessor get a context which is
+// canceled once the call completed.
+func (df *ParallelDataFlow) ProcessContext(ctx context.Context, msg any) (ret any, err error) {
+	ctx, s := df.startSpan(ctx, "parallel")
+	defer func() {
+		s.endWith(err)
+	}()
+	c := df.acquire()
+	infos := c.processors()
+	var wg sync.WaitGroup
+	// the chain is released once the abandoned processors finished too
+	defer func() {
+		go func() {
+			wg.Wait()
+			df.release(c)
+		}()
+	}()
+
+	results := make([]DataFlowResult, len(infos))
+	need := len(infos)
+	switch df.join {
+	case JoinFirstSuccess:
+		need = min(1, len(infos))
+	case JoinQuorum:
+		need = df.quorum
+	}
+	if need > len(infos) {
+		log.Printf("[E]quorum(%d) exceeds processor num(%d)\n", need, len(infos))
+		return results, fmt.Errorf("quorum(%d) exceeds processor num(%d)", need, len(infos))
+	}
+
+	callCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type slot struct {
+		idx    int
+		result DataFlowResult
+	}
+	ch := make(chan slot, len(infos))
+	for i, info := range infos {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ch <- slot{idx: i, result: df.call(callCtx, info, msg)}
+		}()
+	}
+
+	finished := make([]bool, len(infos))
+	pending, succeeded, failed := len(infos), 0, 0
+LOOP:
+	for pending > 0 {
+		select {
+		case s := <-ch:
+			results[s.idx] = s.result
+			finished[s.idx] = true
+			pending--
+			if s.result.Err != nil {
+				failed++
 			} else {
-				return nil, err
+				succeeded++
 			}
-		}
-		outmsg, err := e.Value.(*dataProcessorInfo).p.Process(msg)
-		if err != nil {
-			log.Printf("[E]process failed:%v\n", err)
-			if e.Value.(*dataProcessorInfo).processFaildFunc != nil {
-				df.processorsMux.RUnlock()
-				return e.Value.(*dataProcessorInfo).processFaildFunc(msg, err)
-			} else {
-				return nil, err
+			if df.join == JoinAll {
+				continue
+			}
+			// done once the quorum is reached or can't be anymore
+			if succeeded >= need || len(infos)-failed < need {
+				break LOOP
 			}
+		case <-ctx.Done():
+			break LOOP
+		}
+	}
+	abandoned := ctx.Err()
+	if abandoned == nil {
+		abandoned = context.Canceled
+	}
+	var errs []error
+	for i := range results {
+		if !finished[i] {
+			results[i].Err = abandoned
 		}
-		if outmsgs == nil {
-			outmsgs = make([]any, 0)
+		if results[i].Err != nil {
+			errs = append(errs, results[i].Err)
 		}
-		outmsgs = append(outmsgs, outmsg)
-		e = e.Next()
 	}
-	df.processorsMux.RUnlock()
+	if df.join == JoinAll {
+		if len(errs) > 0 {
+			log.Printf("[E]%d of %d processors failed\n", len(errs), len(infos))
+			return results, fmt.Errorf("%d of %d processors failed:%w", len(errs), len(infos), errors.Join(errs...))
+		}
+		return results, nil
+	}
+	if succeeded < need {
+		log.Printf("[E]%d of %d processors succeeded, %d needed\n", succeeded, len(infos), need)
+		return results, fmt.Errorf("%d of %d processors succeeded, %d needed:%w", succeeded, len(infos), need, errors.Join(errs...))
+	}
+	return results, nil
+}
 
-	return outmsgs, nil
+// call runs a single processor of a fan-out.
+func (df *ParallelDataFlow) call(ctx context.Context, info *dataProcessorInfo, msg any) DataFlowResult {
+	out, err := df.runProcessor(ctx, info, msg)
+	if err != nil {
+		out, err = info.failed(msg, err)
+	}
+	return DataFlowResult{Msg: out, Err: err}
 }
@@ -0,0 +1,135 @@
+package mrun
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestParallel(t *testing.T, options []ParallelOption, fns ...func(msg any) (any, error)) *ParallelDataFlow {
+	t.Helper()
+	df, err := NewParallelDataFlow(options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, fn := range fns {
+		err = df.Register(&funcProcessor{fn: fn}, []DataFlowOption{NewDataFlowOrderOption(uint(i))})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = df.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return df
+}
+
+func sleepThen(d time.Duration, out any, err error) func(msg any) (any, error) {
+	return func(msg any) (any, error) {
+		time.Sleep(d)
+		return out, err
+	}
+}
+
+func TestParallelDataFlowJoinAll(t *testing.T) {
+	fail := errors.New("fail")
+	df := newTestParallel(t, nil,
+		sleepThen(50*time.Millisecond, "a", nil),
+		sleepThen(50*time.Millisecond, nil, fail),
+		sleepThen(50*time.Millisecond, "c", nil))
+	defer df.Destroy()
+
+	start := time.Now()
+	ret, err := df.Process(1)
+	if elapsed := time.Since(start); elapsed > 120*time.Millisecond {
+		t.Fatalf("processors didn't run concurrently, took %v", elapsed)
+	}
+	if !errors.Is(err, fail) {
+		t.Fatalf("got %v, want %v", err, fail)
+	}
+	results := ret.([]DataFlowResult)
+	if len(results) != 3 || results[0].Msg != "a" || !errors.Is(results[1].Err, fail) || results[2].Msg != "c" {
+		t.Fatalf("got %+v", results)
+	}
+}
+
+func TestParallelDataFlowFirstSuccess(t *testing.T) {
+	df := newTestParallel(t, []ParallelOption{NewParallelJoinOption(JoinFirstSuccess)},
+		sleepThen(time.Second, "slow", nil),
+		sleepThen(0, nil, errors.New("fail")),
+		sleepThen(10*time.Millisecond, "fast", nil))
+	defer df.Destroy()
+
+	start := time.Now()
+	ret, err := df.Process(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("waited for the slow processor, took %v", elapsed)
+	}
+	results := ret.([]DataFlowResult)
+	if results[2].Msg != "fast" || !errors.Is(results[0].Err, context.Canceled) || results[1].Err == nil {
+		t.Fatalf("got %+v", results)
+	}
+}
+
+func TestParallelDataFlowQuorum(t *testing.T) {
+	fail := errors.New("fail")
+	df := newTestParallel(t, []ParallelOption{NewParallelQuorumOption(2)},
+		sleepThen(0, "a", nil),
+		sleepThen(0, nil, fail),
+		sleepThen(0, nil, fail))
+	defer df.Destroy()
+	if _, err := df.Process(1); !errors.Is(err, fail) {
+		t.Fatalf("quorum reached with a single success:%v", err)
+	}
+
+	df2 := newTestParallel(t, []ParallelOption{NewParallelQuorumOption(4)}, sleepThen(0, "a", nil))
+	defer df2.Destroy()
+	if _, err := df2.Process(1); err == nil {
+		t.Fatal("quorum larger than the processors accepted")
+	}
+}
+
+func TestParallelDataFlowProcessContext(t *testing.T) {
+	df := newTestParallel(t, nil, sleepThen(0, "a", nil), sleepThen(time.Second, "b", nil))
+	defer df.Destroy()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	ret, err := df.ProcessContext(ctx, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v", err)
+	}
+	results := ret.([]DataFlowResult)
+	if results[0].Msg != "a" || !errors.Is(results[1].Err, context.DeadlineExceeded) {
+		t.Fatalf("got %+v", results)
+	}
+	// MsgCheck failures are attached to the slots too
+	ret, err = df.ProcessContext(context.Background(), "x")
+	if err == nil || ret.([]DataFlowResult)[0].Err == nil {
+		t.Fatalf("MsgCheck error not reported:%+v", ret)
+	}
+}
+
+func TestParallelDataFlowDestroyCancelsProcess(t *testing.T) {
+	df := newTestParallel(t, nil, sleepThen(time.Second, "a", nil))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := df.Process(1)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	go df.Destroy()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want context.Canceled", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Destroy didn't cancel the in-flight Process")
+	}
+}
@@ -0,0 +1,383 @@
+package mrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/odysseythink/mrun/fleets"
+)
+
+const (
+	DEFAULT_PIPELINE_QUEUE_SIZE  = 1024
+	DEFAULT_PIPELINE_WORKERS     = 1
+	DEFAULT_PIPELINE_RESULTS_CAP = 1024
+)
+
+var ErrDataFlowClosed = errors.New("data flow closed")
+
+// DataFlowResult is the outcome of a message pushed through a PipelineDataFlow.
+// Err is set if a processor failed, Msg is then the value returned by the
+// processor's ProcessFaild callback, if any.
+type DataFlowResult struct {
+	Msg any
+	Err error
+}
+
+// NewDataFlowQueueSizeOption sets the capacity of the input queue of a
+// pipeline stage.
+func NewDataFlowQueueSizeOption(size int) func(info *dataProcessorInfo) {
+	return func(info *dataProcessorInfo) {
+		if size <= 0 {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		info.queueSize = size
+	}
+}
+
+// NewDataFlowWorkersOption sets the number of messages a pipeline stage
+// processes concurrently. With more than one worker the stage doesn't keep
+// the order of the messages.
+func NewDataFlowWorkersOption(num int) func(info *dataProcessorInfo) {
+	return func(info *dataProcessorInfo) {
+		if num <= 0 {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		info.workers = num
+	}
+}
+
+type PipelineOption func(*PipelineDataFlow) error
+
+// NewPipelineResultsCapOption sets the capacity of the Results channel.
+func NewPipelineResultsCapOption(cap int) func(*PipelineDataFlow) error {
+	return func(df *PipelineDataFlow) error {
+		if cap < 0 {
+			log.Printf("[E]invalid pipeline results capacity\n")
+			return errors.New("invalid pipeline results capacity")
+		}
+		df.resultsCap = cap
+		return nil
+	}
+}
+
+type pipelineMsg struct {
+	ctx   context.Context
+	msg   any
+	reply chan DataFlowResult
+}
+
+type pipelineStage struct {
+	info *dataProcessorInfo
+	in   chan *pipelineMsg
+	pool *fleets.PoolWithFuncGeneric[*pipelineMsg]
+	wg   sync.WaitGroup
+	next *pipelineStage
+}
+
+// PipelineDataFlow is a streaming data flow. Every processor is a stage with
+// a bounded input queue served by a pool of workers, the output of a stage
+// is queued to the next one in order. Messages are pushed by Submit and come
+// out of Results, which must be drained. When a queue is full the upstream
+// stage waits, down to Submit, so a slow stage slows the producers instead
+// of piling up messages.
+//
+// The stages are built by Init, processors can't be registered afterwards.
+type PipelineDataFlow struct {
+	SequenceDataFlow
+	resultsCap int
+	results    chan DataFlowResult
+	stages     []*pipelineStage
+	startOnce  sync.Once
+	// submitMux guards closed, submitting counts the Submit calls in flight
+	// so the input queue is closed only once they returned
+	submitMux  sync.RWMutex
+	closed     bool
+	submitting sync.WaitGroup
+	closing    chan struct{}
+	closeOnce  sync.Once
+	abort      chan struct{}
+	abortOnce  sync.Once
+	// canceled on abort, it stops the retries of the stages
+	runCtx    context.Context
+	runCancel context.CancelFunc
+	done      chan struct{}
+}
+
+func NewPipelineDataFlow(options ...PipelineOption) (*PipelineDataFlow, error) {
+	df := &PipelineDataFlow{
+		resultsCap: DEFAULT_PIPELINE_RESULTS_CAP,
+	}
+	for _, option := range options {
+		err := option(df)
+		if err != nil {
+			log.Printf("[E]run pipeline option func failed:%v\n", err)
+			return nil, err
+		}
+	}
+	return df, nil
+}
+
+func (df *PipelineDataFlow) Register(p IDataProcessor, options []DataFlowOption, args ...any) error {
+	if df.ctx != nil {
+		log.Printf("[E]pipeline already started\n")
+		return fmt.Errorf("pipeline already started")
+	}
+	return df.SequenceDataFlow.Register(p, options, args...)
+}
+
+func (df *PipelineDataFlow) Init() error {
+	err := df.SequenceDataFlow.Init()
+	if err != nil {
+		return err
+	}
+	df.startOnce.Do(df.start)
+	return err
+}
+
+func (df *PipelineDataFlow) start() {
+	df.submitMux.Lock()
+	df.results = make(chan DataFlowResult, df.resultsCap)
+	df.closing = make(chan struct{})
+	df.abort = make(chan struct{})
+	df.done = make(chan struct{})
+	df.runCtx, df.runCancel = context.WithCancel(df.ctx)
+	df.submitMux.Unlock()
+
+	df.processorsMux.RLock()
+	for e := df.processors.Front(); e != nil; e = e.Next() {
+		info := e.Value.(*dataProcessorInfo)
+		queueSize, workers := info.queueSize, info.workers
+		if queueSize <= 0 {
+			queueSize = DEFAULT_PIPELINE_QUEUE_SIZE
+		}
+		if workers <= 0 {
+			workers = DEFAULT_PIPELINE_WORKERS
+		}
+		stage := &pipelineStage{
+			info: info,
+			in:   make(chan *pipelineMsg, queueSize),
+		}
+		// the pool can't fail with a positive size and a non nil func
+		stage.pool, _ = fleets.NewPoolWithFuncGeneric(workers, func(msg *pipelineMsg) {
+			defer stage.wg.Done()
+			df.process(stage, msg)
+		})
+		if len(df.stages) > 0 {
+			df.stages[len(df.stages)-1].next = stage
+		}
+		df.stages = append(df.stages, stage)
+	}
+	df.processorsMux.RUnlock()
+
+	for _, stage := range df.stages {
+		go df.dispatch(stage)
+	}
+}
+
+// dispatch hands the messages queued to stage to its workers, it waits for
+// a free worker so a busy stage lets its queue fill up. Once the queue is
+// closed and the last message processed, the next queue is closed.
+func (df *PipelineDataFlow) dispatch(stage *pipelineStage) {
+	for msg := range stage.in {
+		stage.wg.Add(1)
+		err := stage.pool.Invoke(msg)
+		if err != nil {
+			stage.wg.Done()
+			log.Printf("[E]pipeline stage invoke failed:%v\n", err)
+			df.emit(msg, DataFlowResult{Err: err})
+		}
+	}
+	stage.wg.Wait()
+	stage.pool.Release()
+	if stage.next != nil {
+		close(stage.next.in)
+		return
+	}
+	close(df.results)
+	close(df.done)
+}
+
+func (df *PipelineDataFlow) process(stage *pipelineStage, msg *pipelineMsg) {
+	select {
+	case <-df.abort:
+		df.emit(msg, DataFlowResult{Err: ErrDataFlowClosed})
+		return
+	default:
+	}
+	if msg.ctx != nil && msg.ctx.Err() != nil {
+		df.emit(msg, DataFlowResult{Err: msg.ctx.Err()})
+		return
+	}
+	info := stage.info
+	ctx := df.runCtx
+	if msg.ctx != nil {
+		// the span of the caller, the run context still stops the retries
+		if sc, ok := SpanFromContext(msg.ctx); ok {
+			ctx = ContextWithSpan(ctx, sc)
+		}
+	}
+	out, err := df.runProcessor(ctx, info, msg.msg)
+	if err != nil {
+		out, err = info.failed(msg.msg, err)
+		df.emit(msg, DataFlowResult{Msg: out, Err: err})
+		return
+	}
+	msg.msg = out
+	if stage.next == nil {
+		df.emit(msg, DataFlowResult{Msg: out})
+		return
+	}
+	select {
+	case stage.next.in <- msg:
+	case <-df.abort:
+		df.emit(msg, DataFlowResult{Err: ErrDataFlowClosed})
+	}
+}
+
+func (df *PipelineDataFlow) emit(msg *pipelineMsg, result DataFlowResult) {
+	if msg.reply != nil {
+		msg.reply <- result
+		return
+	}
+	select {
+	case df.results <- result:
+	case <-df.abort:
+		log.Printf("[D]pipeline aborted, result dropped\n")
+	}
+}
+
+func (df *PipelineDataFlow) submit(msg *pipelineMsg) error {
+	df.submitMux.RLock()
+	if df.closed || df.closing == nil {
+		df.submitMux.RUnlock()
+		return ErrDataFlowClosed
+	}
+	df.submitting.Add(1)
+	df.submitMux.RUnlock()
+	defer df.submitting.Done()
+
+	if len(df.stages) == 0 {
+		df.emit(msg, DataFlowResult{Msg: msg.msg})
+		return nil
+	}
+	var ctxDone <-chan struct{}
+	if msg.ctx != nil {
+		ctxDone = msg.ctx.Done()
+	}
+	select {
+	case df.stages[0].in <- msg:
+		return nil
+	case <-df.closing:
+		return ErrDataFlowClosed
+	case <-ctxDone:
+		return msg.ctx.Err()
+	}
+}
+
+// Submit queues msg to the first stage, it blocks while the queue is full.
+// It fails with ErrDataFlowClosed before Init and once the pipeline is
+// drained or destroyed.
+func (df *PipelineDataFlow) Submit(msg any) error {
+	return df.submit(&pipelineMsg{msg: msg})
+}
+
+// SubmitContext is like Submit, the spans of the stages are children of the
+// span carried by ctx. It stops waiting for room in the queue once ctx is
+// done, and the stages skip msg then, its result has the error of ctx.
+func (df *PipelineDataFlow) SubmitContext(ctx context.Context, msg any) error {
+	return df.submit(&pipelineMsg{ctx: ctx, msg: msg})
+}
+
+// Results returns the channel the outcome of the submitted messages is
+// delivered to. It's closed once the pipeline is drained or destroyed, nil
+// before Init.
+func (df *PipelineDataFlow) Results() <-chan DataFlowResult {
+	return df.results
+}
+
+// Process pushes msg through the pipeline and waits for its outcome, which
+// isn't delivered to Results.
+func (df *PipelineDataFlow) Process(msg any) (any, error) {
+	return df.ProcessContext(context.Background(), msg)
+}
+
+// ProcessContext is like Process, it goes through the stages as SubmitContext
+// does and returns the error of ctx once it's done.
+func (df *PipelineDataFlow) ProcessContext(ctx context.Context, msg any) (any, error) {
+	m := &pipelineMsg{ctx: ctx, msg: msg, reply: make(chan DataFlowResult, 1)}
+	err := df.submit(m)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case result := <-m.reply:
+		return result.Msg, result.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-df.done:
+		// the reply may have been sent just before done was closed
+		select {
+		case result := <-m.reply:
+			return result.Msg, result.Err
+		default:
+			return nil, ErrDataFlowClosed
+		}
+	}
+}
+
+func (df *PipelineDataFlow) close() {
+	df.closeOnce.Do(func() {
+		df.submitMux.Lock()
+		df.closed = true
+		df.submitMux.Unlock()
+		if df.closing == nil {
+			return
+		}
+		close(df.closing)
+		df.submitting.Wait()
+		if len(df.stages) > 0 {
+			close(df.stages[0].in)
+			return
+		}
+		close(df.results)
+		close(df.done)
+	})
+}
+
+// Drain stops accepting messages and waits until the queued ones went
+// through the pipeline, bounded by ctx.
+func (df *PipelineDataFlow) Drain(ctx context.Context) error {
+	df.close()
+	if df.done == nil {
+		return nil
+	}
+	select {
+	case <-df.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Destroy stops the pipeline, the messages still queued are dropped.
+func (df *PipelineDataFlow) Destroy() {
+	df.submitMux.RLock()
+	abort := df.abort
+	df.submitMux.RUnlock()
+	if abort != nil {
+		df.abortOnce.Do(func() {
+			close(abort)
+			df.runCancel()
+		})
+	}
+	df.close()
+	if abort != nil {
+		<-df.done
+	}
+	df.SequenceDataFlow.Destroy()
+}
@@ -0,0 +1,208 @@
+package mrun
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type funcProcessor struct {
+	fn func(msg any) (any, error)
+}
+
+func (p *funcProcessor) Init(args ...any) error {
+	return nil
+}
+func (p *funcProcessor) RunOnce(ctx context.Context) error {
+	return nil
+}
+func (p *funcProcessor) Destroy() {
+}
+func (p *funcProcessor) UserData() any {
+	return nil
+}
+func (p *funcProcessor) MsgCheck(msg any) error {
+	if _, ok := msg.(int); !ok {
+		return errors.New("not an int")
+	}
+	return nil
+}
+func (p *funcProcessor) Process(msg any) (any, error) {
+	return p.fn(msg)
+}
+
+func newTestPipeline(t *testing.T, fns ...func(msg any) (any, error)) *PipelineDataFlow {
+	t.Helper()
+	df, err := NewPipelineDataFlow(NewPipelineResultsCapOption(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, fn := range fns {
+		err = df.Register(&funcProcessor{fn: fn}, []DataFlowOption{NewDataFlowOrderOption(uint(i)), NewDataFlowWorkersOption(4), NewDataFlowQueueSizeOption(8)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = df.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return df
+}
+
+func TestPipelineDataFlow(t *testing.T) {
+	inc := func(msg any) (any, error) { return msg.(int) + 1, nil }
+	double := func(msg any) (any, error) { return msg.(int) * 2, nil }
+	df := newTestPipeline(t, inc, double, inc)
+	defer df.Destroy()
+
+	const n = 20000
+	go func() {
+		for i := range n {
+			if err := df.Submit(i); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		df.Drain(context.Background())
+	}()
+	sum, count := 0, 0
+	for result := range df.Results() {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+		sum += result.Msg.(int)
+		count++
+	}
+	// sum of (2(i+1)+1) for i in [0,n)
+	if want := n*(n+1) + n; count != n || sum != want {
+		t.Fatalf("got %d results summing to %d, want %d summing to %d", count, sum, n, want)
+	}
+	if err := df.Submit(1); !errors.Is(err, ErrDataFlowClosed) {
+		t.Fatalf("submit after drain got %v", err)
+	}
+}
+
+func TestPipelineDataFlowProcess(t *testing.T) {
+	fail := errors.New("odd")
+	df := newTestPipeline(t, func(msg any) (any, error) {
+		if msg.(int)%2 == 1 {
+			return nil, fail
+		}
+		return msg.(int) / 2, nil
+	})
+	defer df.Destroy()
+
+	out, err := df.Process(8)
+	if err != nil || out != 4 {
+		t.Fatalf("got %v %v", out, err)
+	}
+	if _, err = df.Process(3); !errors.Is(err, fail) {
+		t.Fatalf("got %v, want %v", err, fail)
+	}
+	if _, err = df.Process("x"); err == nil {
+		t.Fatal("MsgCheck error not reported")
+	}
+}
+
+func TestPipelineDataFlowProcessContext(t *testing.T) {
+	release := make(chan struct{})
+	var stage atomic.Int32
+	df, _ := NewPipelineDataFlow(NewPipelineResultsCapOption(0))
+	df.Register(&funcProcessor{fn: func(msg any) (any, error) {
+		stage.Add(1)
+		<-release
+		return msg.(int) + 1, nil
+	}}, []DataFlowOption{NewDataFlowQueueSizeOption(1), NewDataFlowWorkersOption(1)})
+	if err := df.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the worker and the dispatcher hold a message each, one is queued
+	for i := range 3 {
+		if err := df.Submit(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := df.ProcessContext(ctx, 10); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ProcessContext on a full pipeline returned %v", err)
+	}
+	close(release)
+	for range 3 {
+		<-df.Results()
+	}
+	out, err := df.ProcessContext(context.Background(), 10)
+	if err != nil || out != 11 {
+		t.Fatalf("got %v %v", out, err)
+	}
+	// ran by the stage, not by the caller
+	if n := stage.Load(); n != 4 {
+		t.Fatalf("stage ran %d times, want 4", n)
+	}
+
+	if err = df.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = df.ProcessContext(context.Background(), 1); !errors.Is(err, ErrDataFlowClosed) {
+		t.Fatalf("ProcessContext after Drain returned %v", err)
+	}
+	df.Destroy()
+}
+
+func TestPipelineDataFlowBackPressure(t *testing.T) {
+	release := make(chan struct{})
+	df, _ := NewPipelineDataFlow(NewPipelineResultsCapOption(0))
+	df.Register(&funcProcessor{fn: func(msg any) (any, error) {
+		<-release
+		return msg, nil
+	}}, []DataFlowOption{NewDataFlowQueueSizeOption(2), NewDataFlowWorkersOption(1)})
+	if err := df.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	var submitted atomic.Int32
+	go func() {
+		for i := range 10 {
+			if df.Submit(i) != nil {
+				return
+			}
+			submitted.Add(1)
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	// one message in the worker, one in the dispatcher, two queued
+	if n := submitted.Load(); n > 4 {
+		t.Fatalf("%d messages submitted to a stalled pipeline", n)
+	}
+	close(release)
+	for range 10 {
+		<-df.Results()
+	}
+	df.Destroy()
+}
+
+func TestPipelineDataFlowDestroy(t *testing.T) {
+	df := newTestPipeline(t, func(msg any) (any, error) { return msg, nil })
+	// nobody reads the results, Destroy must not hang
+	go func() {
+		for i := 0; df.Submit(i) == nil; i++ {
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		df.Destroy()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Destroy hung")
+	}
+	if err := df.Register(&funcProcessor{}, nil); err == nil {
+		t.Fatal("register after Init accepted")
+	}
+}
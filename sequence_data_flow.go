@@ -2,6 +2,7 @@ package mrun
 
 import (
 	"container/list"
+	"context"
 	"fmt"
 	"log"
 )
@@ -70,40 +71,27 @@ func (df *SequenceDataFlow) getDataProcessorByOrder(order uint) IDataProcessor {
 }
 
 func (df *SequenceDataFlow) Process(msg any) (any, error) {
-	df.processorsMux.RLock()
-	if df.processors == nil {
-		df.processors = list.New()
-	}
-	var err error
-	var inmsg any = msg
-	var outmsg any
-	e := df.processors.Front()
-	for e != nil {
-		err = e.Value.(*dataProcessorInfo).p.MsgCheck(msg)
-		if err != nil {
-			log.Printf("[E]MsgCheck failed:%v\n", err)
-			if e.Value.(*dataProcessorInfo).processFaildFunc != nil {
-				df.processorsMux.RUnlock()
-				return e.Value.(*dataProcessorInfo).processFaildFunc(msg, err)
-			} else {
-				return nil, err
-			}
-		}
-		outmsg, err = e.Value.(*dataProcessorInfo).p.Process(inmsg)
+	return df.ProcessContext(df.context(), msg)
+}
+
+// ProcessContext is like Process, ctx is handed to the processors
+// implementing ContextDataProcessor and stops the retries. A span is
+// exported for the call and for every processor if the flow has a span
+// exporter, the processors' latency is recorded in their timers.
+func (df *SequenceDataFlow) ProcessContext(ctx context.Context, msg any) (out any, err error) {
+	ctx, s := df.startSpan(ctx, "sequence")
+	defer func() {
+		s.endWith(err)
+	}()
+	c := df.acquire()
+	defer df.release(c)
+	var outmsg any = msg
+	for _, info := range c.processors() {
+		inmsg := outmsg
+		outmsg, err = df.runProcessor(ctx, info, inmsg)
 		if err != nil {
-			log.Printf("[E]process failed:%v\n", err)
-			if e.Value.(*dataProcessorInfo).processFaildFunc != nil {
-				df.processorsMux.RUnlock()
-				return e.Value.(*dataProcessorInfo).processFaildFunc(inmsg, err)
-			} else {
-				return nil, err
-			}
+			return info.failed(inmsg, err)
 		}
-
-		inmsg = outmsg
-		e = e.Next()
 	}
-	df.processorsMux.RUnlock()
-
 	return outmsg, nil
 }
@@ -8,20 +8,33 @@ import (
 	"reflect"
 	"strconv"
 	"sync"
+	"sync/atomic"
 )
 
+// CallInfo is a queued call of a slot.
 type CallInfo struct {
 	Args []reflect.Value
 	Func reflect.Value
+	conn *Connection
+	// call of a typed signal, run instead of Func
+	call func()
+	// set for a blocking-queued call, receives its result
+	done chan error
 }
 
 type Signal struct {
 	name        string
 	sigFuncType reflect.Type
 	Parameters  []reflect.Type
-	Callbacks   []reflect.Value
+	// Callbacks are the connected slots, replaced as a whole on every
+	// Connect and Disconnect.
+	Callbacks []reflect.Value
 	sync.RWMutex
+	// connections is copied on write, emissions run on the one they read
+	connections    []*Connection
 	callbackCh     chan *CallInfo
+	overflow       OverflowPolicy
+	queue          *signalQueue
 	concurrencyNum int
 	sigConsumers   []*Signal
 }
@@ -33,62 +46,64 @@ func (s *Signal) Destroy() {
 
 }
 func (s *Signal) RunOnce(ctx context.Context) error {
-	select {
-	case cinfo := <-s.callbackCh:
-		if cinfo != nil {
-
-			cinfo.Func.Call(cinfo.Args)
-		}
-	default:
-
-	}
+	s.queue.runOnce()
 	return nil
 }
 func (s *Signal) UserData() any {
 	return nil
 }
 
-func (s *Signal) EmitDirect(args ...any) error {
+func (s *Signal) values(args []any) ([]reflect.Value, error) {
 	if len(s.Parameters) != len(args) {
 		log.Printf("[E]argument %d length doesn't equal to provide length %d \n", len(s.Parameters), len(args))
-		return fmt.Errorf("argument %d length doesn't equal to provide length %d ", len(s.Parameters), len(args))
+		return nil, fmt.Errorf("argument %d length doesn't equal to provide length %d ", len(s.Parameters), len(args))
 	}
 	argValues := make([]reflect.Value, 0, len(s.Parameters))
 	for idx, v := range s.Parameters {
 		t := reflect.TypeOf(args[idx])
 		if v != t {
 			log.Printf("[E]type(argument[%d])=%s doesn't match the type of %s \n", idx, s.Parameters[idx].Name(), t.Name())
-			return fmt.Errorf("[E]type(argument[%d])=%s doesn't match the type of %s", idx, s.Parameters[idx].Name(), t.Name())
+			return nil, fmt.Errorf("[E]type(argument[%d])=%s doesn't match the type of %s", idx, s.Parameters[idx].Name(), t.Name())
 		}
 		argValues = append(argValues, reflect.ValueOf(args[idx]))
 	}
-	for _, cb := range s.Callbacks {
-		cb.Call(argValues)
+	return argValues, nil
+}
+
+func (s *Signal) emit(ctx context.Context, queued bool, args []any) error {
+	argValues, err := s.values(args)
+	if err != nil {
+		return err
 	}
-	return nil
+	return emitTo(ctx, s.slots(), s.queue, queued, func(c *Connection) *CallInfo {
+		return &CallInfo{Func: c.slot, Args: argValues, conn: c}
+	}, func(c *Connection) {
+		c.slot.Call(argValues)
+	})
+}
+
+// EmitDirect calls the slots on the calling goroutine, except those whose
+// delivery mode is queued.
+func (s *Signal) EmitDirect(args ...any) error {
+	return s.emit(context.Background(), false, args)
 }
 
+// Emit queues a call for every slot, except those whose delivery mode is
+// direct.
 func (s *Signal) Emit(args ...any) error {
-	if len(s.Parameters) != len(args) {
-		log.Printf("[E]argument %d length doesn't equal to provide length %d \n", len(s.Parameters), len(args))
-		return fmt.Errorf("argument %d length doesn't equal to provide length %d ", len(s.Parameters), len(args))
-	}
-	argValues := make([]reflect.Value, 0, len(s.Parameters))
-	for idx, v := range s.Parameters {
-		t := reflect.TypeOf(args[idx])
-		if v != t {
-			log.Printf("[E]type(argument[%d])=%s doesn't match the type of %s \n", idx, s.Parameters[idx].Name(), t.Name())
-			return fmt.Errorf("[E]type(argument[%d])=%s doesn't match the type of %s", idx, s.Parameters[idx].Name(), t.Name())
-		}
-		argValues = append(argValues, reflect.ValueOf(args[idx]))
-	}
-	for _, cb := range s.Callbacks {
-		s.callbackCh <- &CallInfo{
-			Func: cb,
-			Args: argValues,
-		}
-	}
-	return nil
+	return s.emit(context.Background(), true, args)
+}
+
+// EmitContext is like Emit, it stops waiting for room in a full queue or for
+// blocking-queued slots once ctx is done, the slots left don't get the
+// emission.
+func (s *Signal) EmitContext(ctx context.Context, args ...any) error {
+	return s.emit(ctx, true, args)
+}
+
+// Dropped returns the number of calls dropped by the overflow policy.
+func (s *Signal) Dropped() uint64 {
+	return s.queue.dropped.Load()
 }
 
 func NewSignalChCapOption(cap int) func(*Signal) error {
@@ -117,16 +132,25 @@ func NewSignalConcurrencyOption(num int) func(*Signal) error {
 
 type SignalOption func(*Signal) error
 
+// NewSignal creates a signal in the namespace of the default App.
 func NewSignal(name string, sigfunc any, options ...SignalOption) (*Signal, error) {
+	return defaultApp.NewSignal(name, sigfunc, options...)
+}
+
+func newSignal(app *App, name string, sigfunc any, options ...SignalOption) (*Signal, error) {
 	if name == "" {
 		log.Printf("[E]missing name\n")
 		return nil, errors.New("missing name")
 	}
+	if err := checkSignalName(name); err != nil {
+		return nil, err
+	}
+	mgr := app.mgr
 	if sigfunc == nil {
 		log.Printf("[E]missing signal func\n")
 		return nil, errors.New("missing signal func")
 	}
-	mods := mSkeleton.GetModulesByAlias(name)
+	mods := mgr.GetModulesByAlias(name)
 	if len(mods) > 0 {
 		log.Printf("[E]signal(%s) already exist\n", name)
 		return nil, fmt.Errorf("signal(%s) already exist", name)
@@ -162,10 +186,9 @@ func NewSignal(name string, sigfunc any, options ...SignalOption) (*Signal, erro
 		}
 	}
 
-	if s.callbackCh == nil {
-		s.callbackCh = make(chan *CallInfo, 1024)
-	}
-	err := mSkeleton.Register(s, []ModuleMgrOption{NewModuleAliasOption(name)}, nil)
+	s.queue = newSignalQueue(s.callbackCh, s.overflow)
+	s.callbackCh = s.queue.ch
+	err := mgr.Register(s, []ModuleMgrOption{NewModuleAliasOption(name)}, nil)
 	if err != nil {
 		log.Printf("[E]register signal model failed:%v\n", err)
 		return nil, err
@@ -176,12 +199,13 @@ func NewSignal(name string, sigfunc any, options ...SignalOption) (*Signal, erro
 			subs := &Signal{
 				name:       name + strconv.Itoa(iLoop),
 				callbackCh: s.callbackCh,
+				queue:      s.queue,
 			}
-			err := mSkeleton.Register(subs, []ModuleMgrOption{NewModuleAliasOption(subs.name)}, nil)
+			err := mgr.Register(subs, []ModuleMgrOption{NewModuleAliasOption(subs.name)}, nil)
 			if err != nil {
-				mSkeleton.UnRegister(s)
+				mgr.UnRegister(s)
 				for _, v := range s.sigConsumers {
-					mSkeleton.UnRegister(v)
+					mgr.UnRegister(v)
 				}
 				log.Printf("[E]register signal model failed:%v\n", err)
 				return nil, err
@@ -189,28 +213,181 @@ func NewSignal(name string, sigfunc any, options ...SignalOption) (*Signal, erro
 			s.sigConsumers = append(s.sigConsumers, subs)
 		}
 	}
+	app.attach(s)
 	return s, nil
 }
 
-func Connect(receiver *Signal, slot any) error {
+// slotOwner is the signal a connection belongs to.
+type slotOwner interface {
+	remove(c *Connection)
+}
+
+// Connection is the link between a signal and a slot made by Connect.
+type Connection struct {
+	signal slotOwner
+	// slot of a Signal
+	slot reflect.Value
+	// slot of a typed signal
+	fn       any
+	once     bool
+	fired    atomic.Bool
+	mode     DeliveryMode
+	priority int
+	// a unique call is queued and not started
+	pending atomic.Bool
+	// set by Disconnect
+	disconnected atomic.Bool
+	stopMux      sync.Mutex
+	// stops the disconnection by the context of ConnectContext
+	stop func() bool
+}
+
+// Connected reports whether the slot still takes emissions.
+func (c *Connection) Connected() bool {
+	return !c.disconnected.Load() && !(c.once && c.fired.Load())
+}
+
+// Disconnect removes the slot from the signal. It's safe to call during an
+// emission, from the slot itself as well, and more than once. Once it
+// returned the slot isn't called anymore, calls already running finish and
+// queued calls are dropped.
+func (c *Connection) Disconnect() {
+	if c.disconnected.Swap(true) {
+		return
+	}
+	c.stopMux.Lock()
+	stop := c.stop
+	c.stopMux.Unlock()
+	if stop != nil {
+		stop()
+	}
+	c.signal.remove(c)
+}
+
+// claim reports whether the slot takes an emission, a one-shot slot takes
+// the first one only and leaves the signal.
+func (c *Connection) claim() bool {
+	if c.disconnected.Load() {
+		return false
+	}
+	if c.once {
+		if !c.fired.CompareAndSwap(false, true) {
+			return false
+		}
+		c.signal.remove(c)
+	}
+	return true
+}
+
+// bind disconnects c once ctx is done.
+func (c *Connection) bind(ctx context.Context) {
+	stop := context.AfterFunc(ctx, c.Disconnect)
+	c.stopMux.Lock()
+	c.stop = stop
+	c.stopMux.Unlock()
+}
+
+// slots returns the current connections, which emissions walk without
+// holding the lock.
+func (s *Signal) slots() []*Connection {
+	s.RLock()
+	defer s.RUnlock()
+	return s.connections
+}
+
+func (s *Signal) add(c *Connection) {
+	s.Lock()
+	defer s.Unlock()
+	s.connections = insertSlot(s.connections, c)
+	callbacks := make([]reflect.Value, 0, len(s.connections))
+	for _, v := range s.connections {
+		callbacks = append(callbacks, v.slot)
+	}
+	s.Callbacks = callbacks
+}
+
+// insertSlot returns a copy of slots with c after the slots of its priority
+// or higher.
+func insertSlot(slots []*Connection, c *Connection) []*Connection {
+	i := 0
+	for i < len(slots) && slots[i].priority >= c.priority {
+		i++
+	}
+	inserted := make([]*Connection, 0, len(slots)+1)
+	inserted = append(inserted, slots[:i]...)
+	inserted = append(inserted, c)
+	return append(inserted, slots[i:]...)
+}
+
+func (s *Signal) remove(c *Connection) {
+	s.Lock()
+	defer s.Unlock()
+	connections := make([]*Connection, 0, len(s.connections))
+	callbacks := make([]reflect.Value, 0, len(s.connections))
+	for _, v := range s.connections {
+		if v != c {
+			connections = append(connections, v)
+			callbacks = append(callbacks, v.slot)
+		}
+	}
+	s.connections = connections
+	s.Callbacks = callbacks
+}
+
+func connect(receiver *Signal, slot any, once bool, options []ConnectOption) (*Connection, error) {
 	if receiver == nil {
 		log.Printf("[E]missing receiver\n")
-		return errors.New("missing receiver")
+		return nil, errors.New("missing receiver")
 	}
 	value := reflect.ValueOf(slot)
 	if value.Kind() != reflect.Func {
 		log.Printf("[E]slot is not function\n")
-		return errors.New("slot is not function")
+		return nil, errors.New("slot is not function")
 	}
 	t := reflect.TypeOf(slot)
 	if receiver.sigFuncType != t {
 		log.Printf("[E]slot (%s) is not matched signal(%s)\n", t.Name(), receiver.sigFuncType.Name())
-		return errors.New("slot is not matched signal")
+		return nil, errors.New("slot is not matched signal")
 	}
 
-	receiver.Lock()
-	receiver.Callbacks = append(receiver.Callbacks, value)
-	receiver.Unlock()
+	c := &Connection{
+		signal: receiver,
+		slot:   value,
+		once:   once,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	receiver.add(c)
+	return c, nil
+}
 
-	return nil
+// Connect connects slot, a function of the signal's type, to receiver. The
+// returned Connection disconnects it.
+func Connect(receiver *Signal, slot any, options ...ConnectOption) (*Connection, error) {
+	return connect(receiver, slot, false, options)
+}
+
+// ConnectOnce is like Connect, the slot is disconnected by the first
+// emission it takes.
+func ConnectOnce(receiver *Signal, slot any, options ...ConnectOption) (*Connection, error) {
+	return connect(receiver, slot, true, options)
+}
+
+// ConnectContext is like Connect, the slot is disconnected once ctx is done.
+func ConnectContext(ctx context.Context, receiver *Signal, slot any, options ...ConnectOption) (*Connection, error) {
+	if ctx == nil {
+		log.Printf("[E]invalid arg\n")
+		return nil, errors.New("invalid arg")
+	}
+	if err := ctx.Err(); err != nil {
+		log.Printf("[E]context done:%v\n", err)
+		return nil, fmt.Errorf("context done:%w", err)
+	}
+	c, err := connect(receiver, slot, false, options)
+	if err != nil {
+		return nil, err
+	}
+	c.bind(ctx)
+	return c, nil
 }
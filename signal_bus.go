@@ -0,0 +1,333 @@
+package mrun
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Signal names are hierarchical, their segments are separated by dots, e.g.
+// device.sensor.temp. In the patterns of Subscribe a * segment matches a
+// single segment and a # segment matches any number of segments, none
+// included: device.*.temp matches device.sensor.temp while device.#
+// matches device and all the signals below it.
+
+// busSignal is a signal of the bus, a Signal or a typed signal.
+type busSignal interface {
+	Name() string
+	ParamTypes() []reflect.Type
+	// connectAny connects slot, which gets the emitted args
+	connectAny(slot func(args []any), options []ConnectOption) (*Connection, error)
+}
+
+func checkSignalName(name string) error {
+	for _, segment := range strings.Split(name, ".") {
+		if segment == "" || strings.ContainsAny(segment, "*#") {
+			log.Printf("[E]invalid signal name(%s)\n", name)
+			return fmt.Errorf("invalid signal name(%s)", name)
+		}
+	}
+	return nil
+}
+
+func checkSignalPattern(pattern string) error {
+	for _, segment := range strings.Split(pattern, ".") {
+		if segment == "" || (segment != "*" && segment != "#" && strings.ContainsAny(segment, "*#")) {
+			log.Printf("[E]invalid signal pattern(%s)\n", pattern)
+			return fmt.Errorf("invalid signal pattern(%s)", pattern)
+		}
+	}
+	return nil
+}
+
+// MatchSignal reports whether the signal name matches pattern.
+func MatchSignal(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "."), strings.Split(name, "."))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "#":
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		case "*":
+		default:
+			if len(name) == 0 || pattern[0] != name[0] {
+				return false
+			}
+		}
+		if len(name) == 0 {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// SignalInfo describes a signal of an App.
+type SignalInfo struct {
+	Name   string
+	Params []reflect.Type
+}
+
+// String formats the signal like a call, e.g. device.sensor.temp(string, float64).
+func (info SignalInfo) String() string {
+	params := make([]string, 0, len(info.Params))
+	for _, t := range info.Params {
+		params = append(params, t.String())
+	}
+	return info.Name + "(" + strings.Join(params, ", ") + ")"
+}
+
+// Name returns the name of the signal.
+func (s *Signal) Name() string {
+	return s.name
+}
+
+// ParamTypes returns the parameter types of the signal.
+func (s *Signal) ParamTypes() []reflect.Type {
+	return append([]reflect.Type(nil), s.Parameters...)
+}
+
+func (s *Signal) connectAny(slot func(args []any), options []ConnectOption) (*Connection, error) {
+	return connect(s, makeAnySlot(s.sigFuncType, slot).Interface(), false, options)
+}
+
+// ParamTypes returns the parameter types of the signal.
+func (s *typedSignal[F]) ParamTypes() []reflect.Type {
+	t := reflect.TypeFor[F]()
+	params := make([]reflect.Type, 0, t.NumIn())
+	for i := range t.NumIn() {
+		params = append(params, t.In(i))
+	}
+	return params
+}
+
+func (s *typedSignal[F]) connectAny(slot func(args []any), options []ConnectOption) (*Connection, error) {
+	return s.connect(makeAnySlot(reflect.TypeFor[F](), slot).Interface().(F), false, options)
+}
+
+// makeAnySlot returns a function of type t handing its args to slot, its
+// results are zero values.
+func makeAnySlot(t reflect.Type, slot func(args []any)) reflect.Value {
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		args := make([]any, 0, len(in))
+		for _, v := range in {
+			args = append(args, v.Interface())
+		}
+		slot(args)
+		out := make([]reflect.Value, 0, t.NumOut())
+		for i := range t.NumOut() {
+			out = append(out, reflect.Zero(t.Out(i)))
+		}
+		return out
+	})
+}
+
+// signals returns the signals of the App.
+func (app *App) signals() []busSignal {
+	var signals []busSignal
+	app.mgr.Range(func(m IModule) bool {
+		// the consumers of NewSignalConcurrencyOption are Signals too
+		if s, ok := m.(*Signal); ok && s.sigFuncType == nil {
+			return true
+		}
+		if s, ok := m.(busSignal); ok {
+			signals = append(signals, s)
+		}
+		return true
+	})
+	return signals
+}
+
+// LookupSignal returns the Signal named name.
+func (app *App) LookupSignal(name string) (*Signal, bool) {
+	for _, m := range app.mgr.GetModulesByAlias(name) {
+		if s, ok := m.(*Signal); ok && s.sigFuncType != nil {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Signals returns the signals of the App, typed ones included, sorted by
+// name.
+func (app *App) Signals() []SignalInfo {
+	var infos []SignalInfo
+	for _, s := range app.signals() {
+		infos = append(infos, SignalInfo{Name: s.Name(), Params: s.ParamTypes()})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Subscription connects a slot to the signals matching a pattern, those
+// created later included.
+type Subscription struct {
+	app     *App
+	pattern string
+	slot    func(name string, args []any)
+	options []ConnectOption
+	// guarded by app.busMux
+	conns []*Connection
+	// the signals connected, a signal is attached both by Subscribe and by
+	// its creation if it's created meanwhile
+	signals map[busSignal]bool
+	closed  bool
+}
+
+// Subscribe connects slot to every signal of the App whose name matches
+// pattern, typed signals included, and to those created later. slot gets
+// the name of the signal and the emitted args, it's called by reflection.
+// options apply to every connection.
+func (app *App) Subscribe(pattern string, slot func(name string, args []any), options ...ConnectOption) (*Subscription, error) {
+	if slot == nil {
+		log.Printf("[E]missing slot\n")
+		return nil, errors.New("missing slot")
+	}
+	if err := checkSignalPattern(pattern); err != nil {
+		return nil, err
+	}
+	sub := &Subscription{
+		app:     app,
+		pattern: pattern,
+		slot:    slot,
+		options: options,
+	}
+	app.busMux.Lock()
+	defer app.busMux.Unlock()
+	for _, s := range app.signals() {
+		err := sub.attach(s)
+		if err != nil {
+			for _, c := range sub.conns {
+				c.Disconnect()
+			}
+			return nil, err
+		}
+	}
+	app.subscriptions = append(app.subscriptions, sub)
+	return sub, nil
+}
+
+// attach connects s to the subscription if it matches and isn't connected
+// yet, app.busMux must be held.
+func (sub *Subscription) attach(s busSignal) error {
+	name := s.Name()
+	if !MatchSignal(sub.pattern, name) || sub.signals[s] {
+		return nil
+	}
+	c, err := s.connectAny(func(args []any) { sub.slot(name, args) }, sub.options)
+	if err != nil {
+		log.Printf("[E]subscribe signal(%s) failed:%v\n", name, err)
+		return fmt.Errorf("subscribe signal(%s) failed:%v", name, err)
+	}
+	if sub.signals == nil {
+		sub.signals = make(map[busSignal]bool)
+	}
+	sub.signals[s] = true
+	sub.conns = append(sub.conns, c)
+	return nil
+}
+
+// Unsubscribe disconnects the slot from all signals, see Connection.Disconnect.
+func (sub *Subscription) Unsubscribe() {
+	app := sub.app
+	app.busMux.Lock()
+	if sub.closed {
+		app.busMux.Unlock()
+		return
+	}
+	sub.closed = true
+	for i, v := range app.subscriptions {
+		if v == sub {
+			app.subscriptions = append(app.subscriptions[:i:i], app.subscriptions[i+1:]...)
+			break
+		}
+	}
+	conns := sub.conns
+	sub.conns = nil
+	app.busMux.Unlock()
+	for _, c := range conns {
+		c.Disconnect()
+	}
+}
+
+// attach connects a new signal to the matching subscriptions.
+func (app *App) attach(s busSignal) {
+	app.busMux.Lock()
+	defer app.busMux.Unlock()
+	for _, sub := range app.subscriptions {
+		sub.attach(s)
+	}
+}
+
+func lookupTypedSignal[F any](app *App, name string) (*typedSignal[F], bool) {
+	if app == nil {
+		app = defaultApp
+	}
+	for _, m := range app.mgr.GetModulesByAlias(name) {
+		if s, ok := m.(*typedSignal[F]); ok {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// LookupSignal0 returns the Signal0 of app, the default App if it's nil,
+// named name. Like for LookupSignal1 to LookupSignal3, a signal of other
+// parameter types isn't found.
+func LookupSignal0(app *App, name string) (*Signal0, bool) {
+	s, ok := lookupTypedSignal[func()](app, name)
+	if !ok {
+		return nil, false
+	}
+	return &Signal0{s}, true
+}
+
+func LookupSignal1[A any](app *App, name string) (*Signal1[A], bool) {
+	s, ok := lookupTypedSignal[func(A)](app, name)
+	if !ok {
+		return nil, false
+	}
+	return &Signal1[A]{s}, true
+}
+
+func LookupSignal2[A, B any](app *App, name string) (*Signal2[A, B], bool) {
+	s, ok := lookupTypedSignal[func(A, B)](app, name)
+	if !ok {
+		return nil, false
+	}
+	return &Signal2[A, B]{s}, true
+}
+
+func LookupSignal3[A, B, C any](app *App, name string) (*Signal3[A, B, C], bool) {
+	s, ok := lookupTypedSignal[func(A, B, C)](app, name)
+	if !ok {
+		return nil, false
+	}
+	return &Signal3[A, B, C]{s}, true
+}
+
+// LookupSignal returns the Signal of the default App named name.
+func LookupSignal(name string) (*Signal, bool) {
+	return defaultApp.LookupSignal(name)
+}
+
+// Signals returns the signals of the default App.
+func Signals() []SignalInfo {
+	return defaultApp.Signals()
+}
+
+// Subscribe subscribes slot to the signals of the default App matching
+// pattern.
+func Subscribe(pattern string, slot func(name string, args []any), options ...ConnectOption) (*Subscription, error) {
+	return defaultApp.Subscribe(pattern, slot, options...)
+}
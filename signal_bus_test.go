@@ -0,0 +1,155 @@
+package mrun
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMatchSignal(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"device.sensor.temp", "device.sensor.temp", true},
+		{"device.*.temp", "device.sensor.temp", true},
+		{"device.*.temp", "device.temp", false},
+		{"device.*.temp", "device.a.b.temp", false},
+		{"device.#", "device", true},
+		{"device.#", "device.sensor.temp", true},
+		{"device.#", "devices.sensor", false},
+		{"#.temp", "device.sensor.temp", true},
+		{"#", "anything.at.all", true},
+		{"device.#.temp", "device.temp", true},
+		{"device.#.temp", "device.a.b.humidity", false},
+		{"*", "device.sensor", false},
+	}
+	for _, c := range cases {
+		if got := MatchSignal(c.pattern, c.name); got != c.want {
+			t.Errorf("MatchSignal(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+func TestSignalBus(t *testing.T) {
+	app, err := NewApp(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp, err := app.NewSignal("device.sensor.temp", func(string, float64) {}, NewSignalConcurrencyOption(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = app.NewSignal("device..temp", func() {}); err == nil {
+		t.Fatal("empty segment accepted")
+	}
+	if _, err = app.NewSignal("device.*", func() {}); err == nil {
+		t.Fatal("wildcard in a signal name accepted")
+	}
+
+	var got []string
+	record := func(name string, args []any) {
+		got = append(got, fmt.Sprint(name, args))
+	}
+	sub, err := app.Subscribe("device.*.temp", record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := app.Subscribe("device.#", func(name string, args []any) {
+		got = append(got, "all:"+name)
+	}, NewConnectPriorityOption(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = app.Subscribe("device.te*", record); err == nil {
+		t.Fatal("partial wildcard accepted")
+	}
+
+	// created after the subscriptions
+	humidity, err := NewSignal1[int](app, "device.room.humidity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := NewSignal1[float64](app, "device.engine.temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, ok := app.LookupSignal("device.sensor.temp")
+	if !ok || s != temp {
+		t.Fatal("LookupSignal didn't find the signal")
+	}
+	if _, ok = app.LookupSignal("device.room.humidity"); ok {
+		t.Fatal("LookupSignal found a typed signal")
+	}
+	if h, ok := LookupSignal1[int](app, "device.room.humidity"); !ok || h.typedSignal != humidity.typedSignal {
+		t.Fatal("LookupSignal1 didn't find the signal")
+	}
+	if _, ok := LookupSignal1[string](app, "device.room.humidity"); ok {
+		t.Fatal("LookupSignal1 found a signal of another type")
+	}
+
+	s.EmitDirect("a", 1.5)
+	humidity.EmitDirect(40)
+	engine.EmitDirect(90.0)
+	want := "[device.sensor.temp[a 1.5] all:device.sensor.temp all:device.room.humidity device.engine.temp[90] all:device.engine.temp]"
+	if fmt.Sprint(got) != want {
+		t.Fatalf("got %v\nwant %s", got, want)
+	}
+
+	sub.Unsubscribe()
+	all.Unsubscribe()
+	sub.Unsubscribe()
+	got = nil
+	s.EmitDirect("b", 2.0)
+	if _, err = NewSignal0(app, "device.late.temp"); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 || len(temp.Callbacks) != 0 {
+		t.Fatalf("unsubscribed slots got %v", got)
+	}
+
+	var names []string
+	for _, info := range app.Signals() {
+		names = append(names, info.String())
+	}
+	wantNames := "device.engine.temp(float64),device.late.temp(),device.room.humidity(int),device.sensor.temp(string, float64)"
+	if strings.Join(names, ",") != wantNames {
+		t.Fatalf("got signals %v", names)
+	}
+}
+
+func TestSignalBusSubscribeDuringCreation(t *testing.T) {
+	for i := range 200 {
+		app, err := NewApp(t.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n atomic.Int32
+		subscribed := make(chan error, 1)
+		go func() {
+			// subscribe as soon as the signal is registered
+			for {
+				if _, ok := LookupSignal1[int](app, "device.temp"); ok {
+					break
+				}
+				runtime.Gosched()
+			}
+			_, err := app.Subscribe("device.#", func(string, []any) { n.Add(1) }, NewConnectDeliveryOption(DeliveryDirect))
+			subscribed <- err
+		}()
+		s, err := NewSignal1[int](app, "device.temp", NewSignalConcurrencyOption(8))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = <-subscribed; err != nil {
+			t.Fatal(err)
+		}
+		s.EmitDirect(i)
+		if got := n.Load(); got != 1 {
+			t.Fatalf("round %d: slot called %d times, want 1", i, got)
+		}
+	}
+}
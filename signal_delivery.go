@@ -0,0 +1,246 @@
+package mrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"sync/atomic"
+)
+
+// ErrSignalDropped is returned by an emission whose call was dropped by the
+// overflow policy of a full signal queue.
+var ErrSignalDropped = errors.New("signal call dropped")
+
+// DeliveryMode tells how an emission reaches a slot.
+type DeliveryMode int
+
+const (
+	// DeliveryAuto queues the call on Emit and calls the slot inline on
+	// EmitDirect, the default.
+	DeliveryAuto DeliveryMode = iota
+	// DeliveryDirect always calls the slot on the emitting goroutine.
+	DeliveryDirect
+	// DeliveryQueued always queues the call for the signal's consumers.
+	DeliveryQueued
+	// DeliveryBlockingQueued queues the call and makes the emitter wait for
+	// it to complete. A slot emitting the same signal from a consumer
+	// deadlocks unless the signal has other consumers.
+	DeliveryBlockingQueued
+	// DeliveryUnique queues the call unless one for the slot is queued and
+	// not started yet, a burst of emissions then makes a single call with
+	// the arguments of the first.
+	DeliveryUnique
+)
+
+// OverflowPolicy tells what a queued emission does when the signal queue is
+// full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for room in the queue, the default.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest drops the call being emitted.
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest queued call to make room.
+	OverflowDropOldest
+)
+
+type ConnectOption func(*Connection)
+
+// NewConnectDeliveryOption sets the delivery mode of the slot.
+func NewConnectDeliveryOption(mode DeliveryMode) ConnectOption {
+	return func(c *Connection) {
+		if mode < DeliveryAuto || mode > DeliveryUnique {
+			log.Printf("[E]invalid arg\n")
+			return
+		}
+		c.mode = mode
+	}
+}
+
+// NewConnectPriorityOption sets the priority of the slot, slots of higher
+// priority get an emission first, slots of equal priority in the order they
+// were connected. Queued calls are started in that order but run
+// concurrently if the signal has several consumers.
+func NewConnectPriorityOption(priority int) ConnectOption {
+	return func(c *Connection) {
+		c.priority = priority
+	}
+}
+
+// NewSignalOverflowOption sets what a queued emission does when the queue of
+// the signal is full.
+func NewSignalOverflowOption(policy OverflowPolicy) func(*Signal) error {
+	return func(s *Signal) error {
+		if policy < OverflowBlock || policy > OverflowDropOldest {
+			log.Printf("[E]invalid signal overflow policy\n")
+			return errors.New("invalid signal overflow policy")
+		}
+		s.overflow = policy
+		return nil
+	}
+}
+
+// signalQueue holds the queued calls of a signal, shared by its consumers.
+type signalQueue struct {
+	ch       chan *CallInfo
+	overflow OverflowPolicy
+	dropped  atomic.Uint64
+}
+
+func newSignalQueue(ch chan *CallInfo, overflow OverflowPolicy) *signalQueue {
+	if ch == nil {
+		ch = make(chan *CallInfo, 1024)
+	}
+	return &signalQueue{ch: ch, overflow: overflow}
+}
+
+func (q *signalQueue) push(ctx context.Context, call *CallInfo) error {
+	switch q.overflow {
+	case OverflowDropNewest:
+		select {
+		case q.ch <- call:
+			return nil
+		default:
+			q.dropped.Add(1)
+			return ErrSignalDropped
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.ch <- call:
+				return nil
+			default:
+			}
+			select {
+			case old := <-q.ch:
+				q.discard(old)
+			default:
+			}
+		}
+	}
+	select {
+	case q.ch <- call:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// discard drops a queued call, its emitter if any learns it.
+func (q *signalQueue) discard(call *CallInfo) {
+	q.dropped.Add(1)
+	if call.conn != nil && call.conn.mode == DeliveryUnique {
+		call.conn.pending.Store(false)
+	}
+	if call.done != nil {
+		call.done <- ErrSignalDropped
+	}
+}
+
+// runOnce runs a queued call, if any.
+func (q *signalQueue) runOnce() {
+	select {
+	case call := <-q.ch:
+		if call != nil {
+			call.run()
+		}
+	default:
+	}
+}
+
+func (call *CallInfo) run() {
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 4096)
+			l := runtime.Stack(buf, false)
+			log.Printf("[E]%v: %s\n", r, buf[:l])
+			err = fmt.Errorf("slot panic: %v", r)
+		}
+		if call.done != nil {
+			call.done <- err
+		}
+	}()
+	c := call.conn
+	if c != nil {
+		if c.mode == DeliveryUnique {
+			// emissions from now on queue a new call
+			c.pending.Store(false)
+		}
+		// calls queued before Disconnect are dropped
+		if c.disconnected.Load() {
+			return
+		}
+	}
+	if call.call != nil {
+		call.call()
+	} else {
+		call.Func.Call(call.Args)
+	}
+}
+
+// deliver hands an emission to c according to its delivery mode, queued
+// tells whether it's emitted by Emit or by EmitDirect. newCall makes the
+// queued call, fn the inline one.
+func (c *Connection) deliver(ctx context.Context, q *signalQueue, queued bool, newCall func() *CallInfo, fn func()) error {
+	if !c.claim() {
+		return nil
+	}
+	mode := c.mode
+	if mode == DeliveryAuto {
+		mode = DeliveryDirect
+		if queued {
+			mode = DeliveryQueued
+		}
+	}
+	switch mode {
+	case DeliveryDirect:
+		fn()
+		return nil
+	case DeliveryUnique:
+		if !c.pending.CompareAndSwap(false, true) {
+			return nil
+		}
+		err := q.push(ctx, newCall())
+		if err != nil {
+			c.pending.Store(false)
+		}
+		return err
+	case DeliveryBlockingQueued:
+		call := newCall()
+		call.done = make(chan error, 1)
+		err := q.push(ctx, call)
+		if err != nil {
+			return err
+		}
+		select {
+		case err = <-call.done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return q.push(ctx, newCall())
+}
+
+// emitTo delivers an emission to slots, it stops once ctx is done. Dropped
+// calls don't stop it, their errors are joined.
+func emitTo(ctx context.Context, slots []*Connection, q *signalQueue, queued bool, newCall func(c *Connection) *CallInfo, fn func(c *Connection)) error {
+	var errs []error
+	for _, c := range slots {
+		if err := ctx.Err(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		err := c.deliver(ctx, q, queued, func() *CallInfo { return newCall(c) }, func() { fn(c) })
+		if err != nil {
+			if ctx.Err() != nil {
+				return errors.Join(append(errs, err)...)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
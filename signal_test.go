@@ -1,9 +1,15 @@
 package mrun
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func F1(val string) string {
@@ -17,7 +23,7 @@ func BenchmarkSignalSend(b *testing.B) {
 		fmt.Println("new signal failed:", err)
 		return
 	}
-	err = Connect(msgSig, F1)
+	_, err = Connect(msgSig, F1)
 	if err != nil {
 		fmt.Println("connect signal failed:", err)
 		return
@@ -28,3 +34,264 @@ func BenchmarkSignalSend(b *testing.B) {
 		msgSig.Emit("message", strconv.Itoa(i))
 	}
 }
+
+func newTestSignal(t *testing.T, sigfunc any) *Signal {
+	t.Helper()
+	app, err := NewApp(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := app.NewSignal("sig", sigfunc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSignalDisconnect(t *testing.T) {
+	s := newTestSignal(t, func(int) {})
+	var a, b []int
+	ca, err := Connect(s, func(v int) { a = append(a, v) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	Connect(s, func(v int) { b = append(b, v) })
+
+	s.EmitDirect(1)
+	ca.Disconnect()
+	ca.Disconnect()
+	s.EmitDirect(2)
+	if len(a) != 1 || len(b) != 2 || ca.Connected() {
+		t.Fatalf("got a=%v b=%v, want a=[1] b=[1 2]", a, b)
+	}
+	if len(s.Callbacks) != 1 {
+		t.Fatalf("%d callbacks left, want 1", len(s.Callbacks))
+	}
+}
+
+func TestSignalDisconnectDuringEmission(t *testing.T) {
+	s := newTestSignal(t, func(int) {})
+	var calls []string
+	var second *Connection
+	// the first slot disconnects itself and the next one
+	var first *Connection
+	first, _ = Connect(s, func(int) {
+		calls = append(calls, "first")
+		first.Disconnect()
+		second.Disconnect()
+	})
+	second, _ = Connect(s, func(int) { calls = append(calls, "second") })
+	Connect(s, func(int) { calls = append(calls, "third") })
+
+	s.EmitDirect(1)
+	s.EmitDirect(2)
+	if got := strings.Join(calls, ","); got != "first,third,third" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestSignalQueuedDisconnect(t *testing.T) {
+	s := newTestSignal(t, func(int) {})
+	var n int
+	c, _ := Connect(s, func(int) { n++ })
+	s.Emit(1)
+	c.Disconnect()
+	// the queued call is dropped
+	s.RunOnce(context.Background())
+	if n != 0 {
+		t.Fatalf("disconnected slot called %d times", n)
+	}
+}
+
+func TestSignalConnectOnce(t *testing.T) {
+	s := newTestSignal(t, func(int) {})
+	var got []int
+	c, err := ConnectOnce(s, func(v int) { got = append(got, v) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Emit(1)
+	s.Emit(2)
+	s.EmitDirect(3)
+	for range 2 {
+		s.RunOnce(context.Background())
+	}
+	if len(got) != 1 || got[0] != 1 {
+		t.Fatalf("one-shot slot got %v, want [1]", got)
+	}
+	if len(s.Callbacks) != 0 || c.Connected() {
+		t.Fatal("one-shot slot not removed after its emission")
+	}
+
+	var n atomic.Int32
+	ConnectOnce(s, func(int) { n.Add(1) })
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.EmitDirect(i)
+		}()
+	}
+	wg.Wait()
+	if n.Load() != 1 {
+		t.Fatalf("one-shot slot called %d times by concurrent emissions", n.Load())
+	}
+}
+
+func TestSignalConnectContext(t *testing.T) {
+	s := newTestSignal(t, func(int) {})
+	ctx, cancel := context.WithCancel(context.Background())
+	var n atomic.Int32
+	c, err := ConnectContext(ctx, s, func(int) { n.Add(1) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.EmitDirect(1)
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for c.Connected() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	s.EmitDirect(2)
+	if n.Load() != 1 || c.Connected() {
+		t.Fatalf("slot called %d times, want 1", n.Load())
+	}
+	if _, err = ConnectContext(ctx, s, func(int) {}); err == nil {
+		t.Fatal("connected with a done context")
+	}
+}
+
+func TestSignalPriority(t *testing.T) {
+	s := newTestSignal(t, func(int) {})
+	var calls []string
+	slot := func(name string) func(int) {
+		return func(int) { calls = append(calls, name) }
+	}
+	Connect(s, slot("low1"), NewConnectPriorityOption(-1))
+	Connect(s, slot("default"))
+	Connect(s, slot("high"), NewConnectPriorityOption(10))
+	Connect(s, slot("low2"), NewConnectPriorityOption(-1))
+
+	s.EmitDirect(1)
+	if got := strings.Join(calls, ","); got != "high,default,low1,low2" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestSignalDeliveryModes(t *testing.T) {
+	s := newTestSignal(t, func(int) {})
+	var direct, queued, unique []int
+	Connect(s, func(v int) { direct = append(direct, v) }, NewConnectDeliveryOption(DeliveryDirect))
+	Connect(s, func(v int) { queued = append(queued, v) }, NewConnectDeliveryOption(DeliveryQueued))
+	Connect(s, func(v int) { unique = append(unique, v) }, NewConnectDeliveryOption(DeliveryUnique))
+
+	s.Emit(1)
+	s.EmitDirect(2)
+	s.Emit(3)
+	if len(direct) != 3 || len(queued) != 0 || len(unique) != 0 {
+		t.Fatalf("before dispatch got direct=%v queued=%v unique=%v", direct, queued, unique)
+	}
+	for range 8 {
+		s.RunOnce(context.Background())
+	}
+	// the unique slot coalesced its three emissions into the first
+	if fmt.Sprint(queued) != "[1 2 3]" || fmt.Sprint(unique) != "[1]" {
+		t.Fatalf("got queued=%v unique=%v", queued, unique)
+	}
+	s.Emit(4)
+	s.RunOnce(context.Background())
+	s.RunOnce(context.Background())
+	if fmt.Sprint(unique) != "[1 4]" {
+		t.Fatalf("unique slot got %v after its call ran", unique)
+	}
+}
+
+func TestSignalBlockingQueued(t *testing.T) {
+	app, err := NewApp(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := app.NewSignal("sig", func(int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var done atomic.Bool
+	Connect(s, func(int) {
+		time.Sleep(20 * time.Millisecond)
+		done.Store(true)
+	}, NewConnectDeliveryOption(DeliveryBlockingQueued))
+
+	// nothing consumes the queue yet
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err = s.EmitContext(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("EmitContext without consumer returned %v", err)
+	}
+
+	runCtx, stop := context.WithCancel(context.Background())
+	exited := make(chan error, 1)
+	go func() { exited <- app.RunContext(runCtx, nil, nil) }()
+	defer func() {
+		stop()
+		<-exited
+	}()
+	done.Store(false)
+	if err = s.Emit(2); err != nil {
+		t.Fatal(err)
+	}
+	if !done.Load() {
+		t.Fatal("blocking-queued Emit returned before the slot completed")
+	}
+}
+
+func TestSignalOverflow(t *testing.T) {
+	for _, c := range []struct {
+		policy OverflowPolicy
+		want   string
+	}{
+		{OverflowDropNewest, "[1 2]"},
+		{OverflowDropOldest, "[2 3]"},
+	} {
+		app, err := NewApp(t.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := app.NewSignal("sig", func(int) {}, NewSignalChCapOption(2), NewSignalOverflowOption(c.policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		Connect(s, func(v int) { got = append(got, v) })
+		for i := 1; i <= 3; i++ {
+			err = s.Emit(i)
+			if c.policy == OverflowDropNewest && i == 3 && !errors.Is(err, ErrSignalDropped) {
+				t.Fatalf("dropped emission returned %v", err)
+			}
+		}
+		for range 3 {
+			s.RunOnce(context.Background())
+		}
+		if fmt.Sprint(got) != c.want || s.Dropped() != 1 {
+			t.Fatalf("policy %d got %v with %d dropped, want %s", c.policy, got, s.Dropped(), c.want)
+		}
+	}
+}
+
+func TestSignalEmitContextFullQueue(t *testing.T) {
+	app, err := NewApp(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := app.NewSignal("sig", func(int) {}, NewSignalChCapOption(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Connect(s, func(int) {})
+	s.Emit(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err = s.EmitContext(ctx, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("EmitContext on a full queue returned %v", err)
+	}
+}
@@ -0,0 +1,294 @@
+package mrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// signalConsumer runs the queued emissions of a typed signal, one per
+// RunOnce. The signal is one, NewSignalConcurrencyOption adds others sharing
+// its queue.
+type signalConsumer struct {
+	queue *signalQueue
+}
+
+func (w *signalConsumer) Init(args ...any) error {
+	return nil
+}
+
+func (w *signalConsumer) Destroy() {
+}
+
+func (w *signalConsumer) RunOnce(ctx context.Context) error {
+	w.queue.runOnce()
+	return nil
+}
+
+func (w *signalConsumer) UserData() any {
+	return nil
+}
+
+// typedSignal is the part of the typed signals shared by all arities, F is
+// the type of their slots.
+type typedSignal[F any] struct {
+	signalConsumer
+	name      string
+	mux       sync.RWMutex
+	conns     []*Connection
+	consumers []*signalConsumer
+}
+
+func newTypedSignal[F any](app *App, name string, options []SignalOption) (*typedSignal[F], error) {
+	if name == "" {
+		log.Printf("[E]missing name\n")
+		return nil, errors.New("missing name")
+	}
+	if err := checkSignalName(name); err != nil {
+		return nil, err
+	}
+	if app == nil {
+		app = defaultApp
+	}
+	mgr := app.mgr
+	mods := mgr.GetModulesByAlias(name)
+	if len(mods) > 0 {
+		log.Printf("[E]signal(%s) already exist\n", name)
+		return nil, fmt.Errorf("signal(%s) already exist", name)
+	}
+
+	// the options of NewSignal set up a Signal, read them back from it
+	cfg := &Signal{name: name}
+	for _, option := range options {
+		err := option(cfg)
+		if err != nil {
+			log.Printf("[E]run signal option func failed:%v\n", err)
+			return nil, err
+		}
+	}
+	s := &typedSignal[F]{name: name}
+	s.queue = newSignalQueue(cfg.callbackCh, cfg.overflow)
+
+	err := mgr.Register(s, []ModuleMgrOption{NewModuleAliasOption(name)}, nil)
+	if err != nil {
+		log.Printf("[E]register signal model failed:%v\n", err)
+		return nil, err
+	}
+	for iLoop := range cfg.concurrencyNum {
+		consumer := &signalConsumer{queue: s.queue}
+		err := mgr.Register(consumer, []ModuleMgrOption{NewModuleAliasOption(name + strconv.Itoa(iLoop))}, nil)
+		if err != nil {
+			mgr.UnRegister(s)
+			for _, v := range s.consumers {
+				mgr.UnRegister(v)
+			}
+			log.Printf("[E]register signal model failed:%v\n", err)
+			return nil, err
+		}
+		s.consumers = append(s.consumers, consumer)
+	}
+	app.attach(s)
+	return s, nil
+}
+
+// Name returns the name of the signal.
+func (s *typedSignal[F]) Name() string {
+	return s.name
+}
+
+func (s *typedSignal[F]) connect(slot F, once bool, options []ConnectOption) (*Connection, error) {
+	if reflect.ValueOf(slot).IsNil() {
+		log.Printf("[E]missing slot\n")
+		return nil, errors.New("missing slot")
+	}
+	c := &Connection{
+		signal: s,
+		fn:     slot,
+		once:   once,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	s.mux.Lock()
+	s.conns = insertSlot(s.conns, c)
+	s.mux.Unlock()
+	return c, nil
+}
+
+// Connect connects slot to the signal, the returned Connection disconnects
+// it.
+func (s *typedSignal[F]) Connect(slot F, options ...ConnectOption) (*Connection, error) {
+	return s.connect(slot, false, options)
+}
+
+// ConnectOnce is like Connect, the slot is disconnected by the first
+// emission it takes.
+func (s *typedSignal[F]) ConnectOnce(slot F, options ...ConnectOption) (*Connection, error) {
+	return s.connect(slot, true, options)
+}
+
+// ConnectContext is like Connect, the slot is disconnected once ctx is done.
+func (s *typedSignal[F]) ConnectContext(ctx context.Context, slot F, options ...ConnectOption) (*Connection, error) {
+	if ctx == nil {
+		log.Printf("[E]invalid arg\n")
+		return nil, errors.New("invalid arg")
+	}
+	if err := ctx.Err(); err != nil {
+		log.Printf("[E]context done:%v\n", err)
+		return nil, fmt.Errorf("context done:%w", err)
+	}
+	c, err := s.connect(slot, false, options)
+	if err != nil {
+		return nil, err
+	}
+	c.bind(ctx)
+	return c, nil
+}
+
+func (s *typedSignal[F]) remove(c *Connection) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	conns := make([]*Connection, 0, len(s.conns))
+	for _, v := range s.conns {
+		if v != c {
+			conns = append(conns, v)
+		}
+	}
+	s.conns = conns
+}
+
+func (s *typedSignal[F]) slots() []*Connection {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.conns
+}
+
+// Dropped returns the number of calls dropped by the overflow policy.
+func (s *typedSignal[F]) Dropped() uint64 {
+	return s.queue.dropped.Load()
+}
+
+// emit delivers an emission made by call to every slot.
+func (s *typedSignal[F]) emit(ctx context.Context, queued bool, call func(F)) error {
+	return emitTo(ctx, s.slots(), s.queue, queued, func(c *Connection) *CallInfo {
+		fn := c.fn.(F)
+		return &CallInfo{conn: c, call: func() { call(fn) }}
+	}, func(c *Connection) {
+		call(c.fn.(F))
+	})
+}
+
+// Signal0 is a signal without parameters. Like Signal, Emit queues the
+// emission for the signal's consumers while EmitDirect calls the slots
+// before returning, unless their delivery mode says otherwise, but the
+// slots are typed so no reflection is involved. Like those of Signal, Emit
+// and EmitDirect report the calls dropped by a full queue, Dropped counts
+// them.
+type Signal0 struct {
+	*typedSignal[func()]
+}
+
+// NewSignal0 creates a signal in the namespace of app, the default App if
+// it's nil. It takes the options of NewSignal.
+func NewSignal0(app *App, name string, options ...SignalOption) (*Signal0, error) {
+	s, err := newTypedSignal[func()](app, name, options)
+	if err != nil {
+		return nil, err
+	}
+	return &Signal0{s}, nil
+}
+
+func (s *Signal0) Emit() error {
+	return s.EmitContext(context.Background())
+}
+
+func (s *Signal0) EmitDirect() error {
+	return s.emit(context.Background(), false, func(fn func()) { fn() })
+}
+
+// EmitContext is like Signal.EmitContext.
+func (s *Signal0) EmitContext(ctx context.Context) error {
+	return s.emit(ctx, true, func(fn func()) { fn() })
+}
+
+// Signal1 is a signal with one parameter, see Signal0.
+type Signal1[A any] struct {
+	*typedSignal[func(A)]
+}
+
+func NewSignal1[A any](app *App, name string, options ...SignalOption) (*Signal1[A], error) {
+	s, err := newTypedSignal[func(A)](app, name, options)
+	if err != nil {
+		return nil, err
+	}
+	return &Signal1[A]{s}, nil
+}
+
+func (s *Signal1[A]) Emit(a A) error {
+	return s.EmitContext(context.Background(), a)
+}
+
+func (s *Signal1[A]) EmitDirect(a A) error {
+	return s.emit(context.Background(), false, func(fn func(A)) { fn(a) })
+}
+
+// EmitContext is like Signal.EmitContext.
+func (s *Signal1[A]) EmitContext(ctx context.Context, a A) error {
+	return s.emit(ctx, true, func(fn func(A)) { fn(a) })
+}
+
+// Signal2 is a signal with two parameters, see Signal0.
+type Signal2[A, B any] struct {
+	*typedSignal[func(A, B)]
+}
+
+func NewSignal2[A, B any](app *App, name string, options ...SignalOption) (*Signal2[A, B], error) {
+	s, err := newTypedSignal[func(A, B)](app, name, options)
+	if err != nil {
+		return nil, err
+	}
+	return &Signal2[A, B]{s}, nil
+}
+
+func (s *Signal2[A, B]) Emit(a A, b B) error {
+	return s.EmitContext(context.Background(), a, b)
+}
+
+func (s *Signal2[A, B]) EmitDirect(a A, b B) error {
+	return s.emit(context.Background(), false, func(fn func(A, B)) { fn(a, b) })
+}
+
+// EmitContext is like Signal.EmitContext.
+func (s *Signal2[A, B]) EmitContext(ctx context.Context, a A, b B) error {
+	return s.emit(ctx, true, func(fn func(A, B)) { fn(a, b) })
+}
+
+// Signal3 is a signal with three parameters, see Signal0.
+type Signal3[A, B, C any] struct {
+	*typedSignal[func(A, B, C)]
+}
+
+func NewSignal3[A, B, C any](app *App, name string, options ...SignalOption) (*Signal3[A, B, C], error) {
+	s, err := newTypedSignal[func(A, B, C)](app, name, options)
+	if err != nil {
+		return nil, err
+	}
+	return &Signal3[A, B, C]{s}, nil
+}
+
+func (s *Signal3[A, B, C]) Emit(a A, b B, c C) error {
+	return s.EmitContext(context.Background(), a, b, c)
+}
+
+func (s *Signal3[A, B, C]) EmitDirect(a A, b B, c C) error {
+	return s.emit(context.Background(), false, func(fn func(A, B, C)) { fn(a, b, c) })
+}
+
+// EmitContext is like Signal.EmitContext.
+func (s *Signal3[A, B, C]) EmitContext(ctx context.Context, a A, b B, c C) error {
+	return s.emit(ctx, true, func(fn func(A, B, C)) { fn(a, b, c) })
+}
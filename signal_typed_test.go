@@ -0,0 +1,150 @@
+package mrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSignal2EmitDirect(t *testing.T) {
+	app, err := NewApp(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSignal2[string, error](app, "failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewSignal2[string, error](app, "failed"); err == nil {
+		t.Fatal("duplicated signal accepted")
+	}
+	var got []string
+	// an interface typed parameter takes any implementation
+	c, err := s.Connect(func(name string, err error) { got = append(got, name+":"+err.Error()) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ConnectOnce(func(name string, err error) { got = append(got, "once") })
+	if _, err = s.Connect(nil); err == nil {
+		t.Fatal("nil slot accepted")
+	}
+
+	s.EmitDirect("a", errors.New("x"))
+	s.EmitDirect("b", fmt.Errorf("wrapped:%w", context.Canceled))
+	c.Disconnect()
+	s.EmitDirect("c", errors.New("z"))
+	want := []string{"a:x", "once", "b:wrapped:context canceled"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestSignal1EmitQueued(t *testing.T) {
+	app, err := NewApp(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSignal1[int](app, "changed", NewSignalConcurrencyOption(4), NewSignalChCapOption(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum, n atomic.Int64
+	s.Connect(func(v int) {
+		sum.Add(int64(v))
+		n.Add(1)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- app.RunContext(ctx, nil, nil) }()
+	for i := 1; i <= 100; i++ {
+		s.Emit(i)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for n.Load() != 100 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n.Load() != 100 || sum.Load() != 5050 {
+		t.Fatalf("got %d calls summing to %d, want 100 summing to 5050", n.Load(), sum.Load())
+	}
+}
+
+func TestSignal0ConnectContext(t *testing.T) {
+	app, err := NewApp(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSignal0(app, "tick")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var n atomic.Int32
+	c, err := s.ConnectContext(ctx, func() { n.Add(1) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Emit()
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for c.Connected() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// the call queued before the disconnection is dropped
+	s.RunOnce(context.Background())
+	s.EmitDirect()
+	if n.Load() != 0 {
+		t.Fatalf("disconnected slot called %d times", n.Load())
+	}
+}
+
+func BenchmarkSignal1Send(b *testing.B) {
+	app, err := NewApp(b.Name())
+	if err != nil {
+		b.Fatal(err)
+	}
+	s, err := NewSignal1[string](app, "message_was_created")
+	if err != nil {
+		b.Fatal(err)
+	}
+	s.Connect(func(string) {})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.EmitDirect(strconv.Itoa(i))
+	}
+}
+
+func TestSignal1DeliveryModes(t *testing.T) {
+	app, err := NewApp(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSignal1[int](app, "changed", NewSignalChCapOption(1), NewSignalOverflowOption(OverflowDropNewest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []string
+	s.Connect(func(v int) { calls = append(calls, "queued"+strconv.Itoa(v)) }, NewConnectDeliveryOption(DeliveryQueued))
+	s.Connect(func(v int) { calls = append(calls, "first"+strconv.Itoa(v)) }, NewConnectPriorityOption(1))
+
+	if err = s.EmitDirect(1); err != nil {
+		t.Fatalf("EmitDirect returned %v", err)
+	}
+	if err = s.Emit(2); !errors.Is(err, ErrSignalDropped) {
+		t.Fatalf("Emit on a full queue returned %v", err)
+	}
+	s.RunOnce(context.Background())
+	s.RunOnce(context.Background())
+	if got := fmt.Sprint(calls); got != "[first1 queued1]" || s.Dropped() != 2 {
+		t.Fatalf("got %s with %d dropped", got, s.Dropped())
+	}
+}
@@ -1,20 +1,20 @@
 package mrun
 
 import (
+	"container/list"
 	"context"
-	"log"
 	"os"
-	"os/signal"
-	"syscall"
-
-	"github.com/odysseythink/mrun/fleets"
+	"time"
 )
 
-var (
-	mSkeleton = &ModuleMgr{
-		name: "root_module_mgr",
-	}
-)
+var defaultApp = &App{
+	name: os.Args[0],
+	mgr: &ModuleMgr{
+		name:    "root_module_mgr",
+		modules: list.New(),
+	},
+	shutdownGracePeriod: DEFAULT_SHUTDOWN_GRACE_PERIOD,
+}
 
 // Context interface contains an optional Context function which a Service can implement.
 // When implemented the context.Done() channel will be used in addition to signal handling
@@ -23,54 +23,41 @@ type Context interface {
 	Context() context.Context
 }
 
+// DefaultApp returns the App the package level functions work on.
+func DefaultApp() *App {
+	return defaultApp
+}
+
 func Register(m IModule, options []ModuleMgrOption, args []any) error {
-	return mSkeleton.Register(m, options, args...)
+	return defaultApp.Register(m, options, args)
+}
+
+// RegisterTyped registers a typed module, cfg is passed to its Init.
+func RegisterTyped[C any](m TypedModule[C], options []ModuleMgrOption, cfg C) error {
+	return RegisterTypedModule(defaultApp.mgr, m, options, cfg)
 }
 
 func RegisterLibso(libname string, options []ModuleMgrOption, args []any) error {
-	return mSkeleton.RegisterLibso(libname, options, args...)
+	return defaultApp.RegisterLibso(libname, options, args)
 }
 
 func RegisterLibsoWithModule(libname, modulename string, options []ModuleMgrOption, args []any) error {
-	return mSkeleton.RegisterLibsoWithModule(libname, modulename, options, args...)
+	return defaultApp.RegisterLibsoWithModule(libname, modulename, options, args)
 }
 
-func RunWithArgs(m IModule, args []any, sig ...os.Signal) error {
-	var ctx context.Context
-	if m != nil {
-		mSkeleton.Register(m, nil, args)
-		if s, ok := m.(Context); ok && s != nil {
-			ctx = s.Context()
-		}
-	}
-
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	mSkeleton.ctx, mSkeleton.ctxCancelFunc = context.WithCancel(ctx)
-
-	err := mSkeleton.Init()
-	if err != nil {
-		log.Printf("[E]skeleton init failed:%v\n", err)
-		return err
-	}
-	if len(sig) == 0 {
-		sig = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
-	}
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, sig...)
+// SetShutdownGracePeriod bounds the time Run and RunWithArgs wait for the
+// modules to stop once the process is asked to exit, 0 waits forever.
+func SetShutdownGracePeriod(d time.Duration) {
+	defaultApp.shutdownGracePeriod = d
+}
 
-	select {
-	case sigQuit := <-signalChan:
-		log.Printf("[D]%s Server closing by signal %v\n", os.Args[0], sigQuit)
-	case <-ctx.Done():
-		log.Printf("[D]%s Server closing by context done\n", os.Args[0])
-	}
+// Supervise sets the restart policy of the modules registered by Register.
+func Supervise(options ...SupervisorOption) {
+	defaultApp.Supervise(options...)
+}
 
-	mSkeleton.Destroy()
-	fleets.Release()
-	log.Printf("[D]%s Server End!\n", os.Args[0])
-	return nil
+func RunWithArgs(m IModule, args []any, sig ...os.Signal) error {
+	return defaultApp.RunWithArgs(m, args, sig...)
 }
 
 func Run(m IModule, sig ...os.Signal) error {